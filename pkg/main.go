@@ -4,12 +4,12 @@ import (
 	"context"
 	"os"
 
-	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/redshift-datasource/pkg/redshift"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
 	"github.com/grafana/redshift-datasource/pkg/redshift/routes"
 )
 
@@ -28,10 +28,21 @@ func MakeDatasourceFactory() datasource.InstanceFactoryFunc {
 	return func(ctx context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
 		log.DefaultLogger.FromContext(ctx).Debug("building new datasource instance")
 		s := redshift.New()
-		ds := awsds.NewAsyncAWSDatasource(s)
-		ds.Completable = s
+		ds := redshift.NewAsyncAWSDatasource(s)
 		ds.CustomRoutes = routes.New(s).Routes()
-		ds.EnableRowLimit = true
-		return ds.NewDatasource(ctx, settings)
+		if _, err := ds.NewDatasource(ctx, settings); err != nil {
+			return nil, err
+		}
+		// incomplete or invalid settings are allowed, for the configuration page to list clusters and secrets, but logged
+		redshiftSettings := &models.RedshiftDataSourceSettings{}
+		if err := redshiftSettings.Load(settings); err == nil {
+			if err := redshiftSettings.Validate(); err != nil {
+				log.DefaultLogger.FromContext(ctx).Warn("datasource settings are incomplete, queries will fail", "uid", settings.UID, "error", err)
+			}
+			for _, warning := range redshiftSettings.Warnings() {
+				log.DefaultLogger.FromContext(ctx).Warn("datasource setting ignored", "uid", settings.UID, "field", warning.Field, "warning", warning.Message)
+			}
+		}
+		return redshift.NewAsyncDatasource(ds, s), nil
 	}
 }
@@ -2,9 +2,13 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/grafana/grafana-aws-sdk/pkg/awsauth"
 	"github.com/grafana/redshift-datasource/pkg/redshift/api/types"
@@ -15,7 +19,8 @@ import (
 	redshiftdatatypes "github.com/aws/aws-sdk-go-v2/service/redshiftdata/types"
 	"github.com/aws/aws-sdk-go-v2/service/redshiftserverless"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
-	secretsmanagertypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 
 	"github.com/grafana/grafana-aws-sdk/pkg/sql/api"
 	awsModels "github.com/grafana/grafana-aws-sdk/pkg/sql/models"
@@ -25,15 +30,27 @@ import (
 	"github.com/grafana/sqlds/v5"
 )
 
+// stopTimeout bounds the time spent canceling a statement
+const stopTimeout = 30 * time.Second
+
 type API struct {
 	DataClient                 types.RedshiftDataClient
 	SecretsClient              types.RedshiftSecretsClient
 	ManagementClient           types.RedshiftManagementClient
 	ServerlessManagementClient types.ServerlessAPIClient
 	settings                   *models.RedshiftDataSourceSettings
+	scheduler                  *scheduler
+	sessions                   *sessionPool
+	results                    *resultCache
+	served                     *statementLog
+	owners                     *statementLog
+	secret                     *managedSecret
+	// credentials are those of Data API calls, checked by the health check
+	credentials aws.CredentialsProvider
 }
 
-func New(ctx context.Context, settings awsModels.Settings) (api.AWSAPI, error) {
+// New returns an API instance for the settings, sharing the state of the datasource instance
+func New(ctx context.Context, settings awsModels.Settings, state *DatasourceState) (api.AWSAPI, error) {
 	redshiftSettings := settings.(*models.RedshiftDataSourceSettings)
 
 	httpClientProvider := sdkhttpclient.NewProvider()
@@ -73,14 +90,34 @@ func New(ctx context.Context, settings awsModels.Settings) (api.AWSAPI, error) {
 	if err != nil {
 		return nil, err
 	}
+	state.init(redshiftSettings)
+	awsCfg.Retryer = newRetryer(redshiftSettings, state.retryBudget)
+
+	credentials := awsCfg.Credentials
+	if redshiftSettings.IdentityPropagation != "" {
+		// the datasource credentials are only used to get those of the user
+		credentials = newIdentityCredentials(redshiftSettings, sts.NewFromConfig(awsCfg), ssooidc.NewFromConfig(awsCfg))
+	}
+	dataClient := redshiftdata.NewFromConfig(awsCfg, func(options *redshiftdata.Options) {
+		options.Credentials = credentials
+	})
 
-	return &API{
-		DataClient:                 redshiftdata.NewFromConfig(awsCfg),
+	c := &API{
+		DataClient:                 dataClient,
 		SecretsClient:              secretsmanager.NewFromConfig(awsCfg),
 		ManagementClient:           redshift.NewFromConfig(awsCfg),
 		ServerlessManagementClient: redshiftserverless.NewFromConfig(awsCfg),
 		settings:                   redshiftSettings,
-	}, nil
+		scheduler:                  state.scheduler,
+		sessions:                   state.sessions,
+		results:                    state.results,
+		served:                     state.targets,
+		owners:                     state.owners,
+		secret:                     state.managedSecret(redshiftSettings),
+		credentials:                credentials,
+	}
+	c.resolveSecret(ctx)
+	return c, nil
 }
 
 type apiInput struct {
@@ -89,6 +126,8 @@ type apiInput struct {
 	Database          *string
 	DbUser            *string
 	SecretARN         *string
+	// User is the Grafana user whose own AWS credentials are used, with identity propagation
+	User string
 }
 
 func (c *API) apiInput() apiInput {
@@ -102,62 +141,282 @@ func (c *API) apiInput() apiInput {
 	// Serverless + Managed Secret
 	case c.settings.UseServerless && c.settings.UseManagedSecret:
 		res.WorkgroupName = aws.String(c.settings.WorkgroupName)
-		res.SecretARN = aws.String(c.settings.ManagedSecret.ARN)
+		res.SecretARN = aws.String(c.secretARN())
 	// Provisioned + Temporary credential
 	case !c.settings.UseServerless && !c.settings.UseManagedSecret:
 		res.ClusterIdentifier = aws.String(c.settings.ClusterIdentifier)
-		res.DbUser = aws.String(c.settings.DBUser)
+		// with identity propagation, the database user is the IAM identity of the user
+		if c.settings.IdentityPropagation == "" {
+			res.DbUser = aws.String(c.settings.DBUser)
+		}
 	// Provisioned + Managed Secret
 	case !c.settings.UseServerless && c.settings.UseManagedSecret:
 		res.ClusterIdentifier = aws.String(c.settings.ClusterIdentifier)
-		res.SecretARN = aws.String(c.settings.ManagedSecret.ARN)
+		res.SecretARN = aws.String(c.secretARN())
 	}
 	return res
 }
 
+// userInput returns the input of the user of the request, whose identity may be mapped to its own
+// database user or secret, or propagated
+func (c *API) userInput(ctx context.Context) (apiInput, error) {
+	res := c.apiInput()
+	if c.settings.IdentityPropagation != "" {
+		if user := backend.UserFromContext(ctx); user != nil {
+			res.User = user.Login
+		}
+		return res, nil
+	}
+	mapping, err := c.settings.UserIdentity(backend.UserFromContext(ctx))
+	if err != nil {
+		return res, backend.DownstreamError(err)
+	}
+	if mapping != nil {
+		res.DbUser, res.SecretARN = nil, nil
+		if mapping.SecretARN != "" {
+			res.SecretARN = aws.String(mapping.SecretARN)
+		} else {
+			res.DbUser = aws.String(mapping.DBUser)
+		}
+	}
+	return res, nil
+}
+
+// Execute submits the query through the datasource scheduler. If the datasource already has
+// too many statements running, the returned ID is a ticket that identifies the queued query.
+// If the result cache is enabled and holds a statement that ran the same query, its ID is returned instead.
+// Read-only datasources reject queries with statements they do not allow.
 func (c *API) Execute(ctx context.Context, input *api.ExecuteQueryInput) (*api.ExecuteQueryOutput, error) {
-	commonInput := c.apiInput()
+	if err := c.settings.CheckStatements(input.Query); err != nil {
+		return nil, backend.DownstreamError(err)
+	}
+	c.checkSecretRotation(ctx)
+	commonInput, err := c.userInput(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key := c.queryKey(commonInput, input.Query)
+	if id, ok := c.results.get(key); ok {
+		backend.Logger.Debug("reusing the result of a finished statement", "queryID", id)
+		if len(c.settings.FailoverTargets) > 0 {
+			c.servedBy(ctx, id, c.served.get(id))
+		}
+		c.ownedBy(id, commonInput)
+		return &api.ExecuteQueryOutput{ID: id}, nil
+	}
+	id, err := c.scheduler.execute(ctx, c, key, func(ctx context.Context) (string, error) {
+		return c.executeStatement(ctx, input)
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.ownedBy(id, commonInput)
+	return &api.ExecuteQueryOutput{ID: id}, nil
+}
+
+// queryKey identifies a query by its normalized SQL, which includes the expanded time range, the target
+// and identity it runs with, and the version of the datasource settings
+func (c *API) queryKey(input apiInput, query string) string {
+	datasource := fmt.Sprintf("%d/%s", c.settings.Config.ID, c.settings.Config.Updated)
+	sum := sha256.Sum256([]byte(datasource + "\n" + sessionKey(input) + "\n" + models.NormalizeSQL(query)))
+	return hex.EncodeToString(sum[:])
+}
+
+// executeStatement runs the statement on the target of the datasource, failing over to the next
+// target if it is paused, unavailable or throttled. Paused clusters are resumed if the datasource allows it.
+func (c *API) executeStatement(ctx context.Context, input *api.ExecuteQueryInput) (string, error) {
+	initStatements, err := c.settings.SessionInitStatements()
+	if err != nil {
+		return "", err
+	}
+	initStatements = append(queryGroupStatement(c.settings), initStatements...)
+	name, sql := tagStatement(ctx, c.settings, input.Query)
+	targets, err := c.targets(ctx)
+	if err != nil {
+		return "", err
+	}
+	for i, target := range targets {
+		var id string
+		id, err = c.executeOn(ctx, target.input, name, sql, initStatements)
+		if err != nil && target.input.ClusterIdentifier != nil {
+			// a paused cluster is only waited for when there is no other target to fail over to
+			err = c.checkPaused(ctx, *target.input.ClusterIdentifier, err, i == len(targets)-1)
+		}
+		if err == nil {
+			c.servedBy(ctx, id, target.name)
+			// the owner is the identity of the user, whichever target ran the statement
+			c.ownedBy(id, targets[0].input)
+			return id, nil
+		}
+		if i == len(targets)-1 || !isFailoverError(err) {
+			break
+		}
+		backend.Logger.Warn("failing over to the next target", "target", target.name, "next", targets[i+1].name, "error", err)
+	}
+	var resuming *resumingError
+	if errors.As(err, &resuming) {
+		return "", err
+	}
+	return "", backend.DownstreamError(fmt.Errorf("%w: %v", api.ErrorExecute, err))
+}
+
+// executeOn runs the statement on a target, in an idle session of the target if there is one
+func (c *API) executeOn(ctx context.Context, commonInput apiInput, name, sql string, initStatements []string) (string, error) {
 	redshiftInput := &redshiftdata.ExecuteStatementInput{
 		ClusterIdentifier: commonInput.ClusterIdentifier,
 		Database:          commonInput.Database,
 		DbUser:            commonInput.DbUser,
 		SecretArn:         commonInput.SecretARN,
-		Sql:               aws.String(input.Query),
+		Sql:               aws.String(sql),
+		StatementName:     aws.String(name),
 		WithEvent:         aws.Bool(c.settings.WithEvent),
 		WorkgroupName:     commonInput.WorkgroupName,
 	}
-	output, err := c.DataClient.ExecuteStatement(ctx, redshiftInput, func(options *redshiftdata.Options) {
+	key := sessionKey(commonInput)
+	sess := c.sessions.acquire(key)
+	if sess != nil {
+		// the connection parameters are those of the session
+		redshiftInput = &redshiftdata.ExecuteStatementInput{
+			Sql:           redshiftInput.Sql,
+			StatementName: redshiftInput.StatementName,
+			WithEvent:     redshiftInput.WithEvent,
+			SessionId:     aws.String(sess.id),
+		}
+	}
+	if c.sessions != nil {
+		redshiftInput.SessionKeepAliveSeconds = c.sessions.keepAliveSeconds()
+	}
+	id, sessionID, err := c.submit(ctx, redshiftInput, initStatements)
+	if err != nil && sess != nil && !isThrottlingError(err) {
+		// the session expired or died, fall back to another one
+		backend.Logger.Debug("failed to reuse session", "sessionId", sess.id, "error", err)
+		return c.executeOn(ctx, commonInput, name, sql, initStatements)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if sessionID != nil {
+		c.sessions.checkout(id, &session{id: *sessionID, key: key})
+	}
+	return id, nil
+}
+
+// submit runs the statement. If there are session initialization statements, they are run
+// in a batch before the statement and the ID of the last statement of the batch is returned.
+func (c *API) submit(ctx context.Context, input *redshiftdata.ExecuteStatementInput, initStatements []string) (string, *string, error) {
+	withRegion := func(options *redshiftdata.Options) {
 		if c.settings.Region != "" {
 			options.Region = c.settings.Region
 		} else {
 			options.Region = c.settings.DefaultRegion
 		}
-	})
+	}
+	if len(initStatements) == 0 {
+		output, err := c.DataClient.ExecuteStatement(ctx, input, withRegion)
+		if err != nil {
+			return "", nil, err
+		}
+		return *output.Id, output.SessionId, nil
+	}
+
+	sqls := append(append([]string{}, initStatements...), *input.Sql)
+	output, err := c.DataClient.BatchExecuteStatement(ctx, &redshiftdata.BatchExecuteStatementInput{
+		Sqls:                    sqls,
+		ClusterIdentifier:       input.ClusterIdentifier,
+		Database:                input.Database,
+		DbUser:                  input.DbUser,
+		SecretArn:               input.SecretArn,
+		SessionId:               input.SessionId,
+		SessionKeepAliveSeconds: input.SessionKeepAliveSeconds,
+		StatementName:           input.StatementName,
+		WithEvent:               input.WithEvent,
+		WorkgroupName:           input.WorkgroupName,
+	}, withRegion)
 	if err != nil {
-		return nil, backend.DownstreamError(fmt.Errorf("%w: %v", api.ErrorExecute, err))
+		return "", nil, err
+	}
+	// sub-statements are identified by the batch ID and their position
+	return fmt.Sprintf("%s:%d", *output.Id, len(sqls)), output.SessionId, nil
+}
+
+// batchID returns the ID of the batch a sub-statement belongs to, or the ID itself for other statements
+func batchID(id string) string {
+	if i := strings.LastIndex(id, ":"); i >= 0 {
+		return id[:i]
 	}
+	return id
+}
+
+// statementFinished is called by the scheduler once a statement it tracks has finished
+func (c *API) statementFinished(id, key string, succeeded bool) {
+	c.sessions.release(id)
+	if succeeded {
+		c.results.add(key, id)
+	}
+}
+
+// MaxExecutionTime returns how long a statement can run before it is canceled. Zero means no limit.
+func (c *API) MaxExecutionTime() time.Duration {
+	return time.Duration(c.settings.MaxExecutionTimeSeconds) * time.Second
+}
 
-	return &api.ExecuteQueryOutput{ID: *output.Id}, nil
+// WhenSubmitted calls fn once the query has been submitted to Redshift, right away unless it is queued.
+// fn is not called for queued queries that are canceled or fail to be submitted.
+func (c *API) WhenSubmitted(queryID string, fn func()) {
+	c.scheduler.whenSubmitted(queryID, fn)
 }
 
-// GetQueryID always returns not found. To actually check if the query has been called requires calling ListStatements, which can lead to timeouts
-// when there are many statements to page through
-func (c *API) GetQueryID(_ context.Context, _ string, _ ...interface{}) (bool, string, error) {
-	return false, "", nil
+// StatementID returns the Redshift statement ID of a query. It differs from the query ID
+// returned by Execute only while the query is queued.
+func (c *API) StatementID(queryID string) string {
+	return c.scheduler.statementID(queryID)
 }
 
+// GetQueryID returns the ID of a statement of the datasource running the same query, so that
+// repeated requests (e.g. after a browser refresh) attach to it instead of running the query again.
+// Queued and running statements are looked up in the scheduler, rather than with ListStatements, which
+// can lead to timeouts when there are many statements to page through. Finished statements are only
+// returned from the result cache, when it is enabled.
+func (c *API) GetQueryID(ctx context.Context, query string, _ ...interface{}) (bool, string, error) {
+	commonInput, err := c.userInput(ctx)
+	if err != nil {
+		return false, "", err
+	}
+	key := c.queryKey(commonInput, query)
+	if id, ok := c.scheduler.lookup(key); ok {
+		return true, id, nil
+	}
+	id, ok := c.results.get(key)
+	return ok, id, nil
+}
+
+// Status returns the status of a query. Statements tracked by the datasource scheduler are
+// answered from its last poll, others are described directly. Queries of other users are rejected,
+// see CheckOwner.
 func (c *API) Status(ctx context.Context, output *api.ExecuteQueryOutput) (*api.ExecuteQueryStatus, error) {
+	if err := c.CheckOwner(ctx, output.ID); err != nil {
+		return nil, err
+	}
+	if status, err, ok := c.scheduler.status(output.ID); ok {
+		return status, err
+	}
+	if strings.HasPrefix(output.ID, queuedPrefix) {
+		return nil, backend.DownstreamError(errExpiredTicket)
+	}
+	return c.describeStatus(ctx, output.ID)
+}
+
+// describeStatus calls DescribeStatement. If the statement failed both the status and the error are returned.
+func (c *API) describeStatus(ctx context.Context, id string) (*api.ExecuteQueryStatus, error) {
+	// the status of a batch includes the error of the statement that made it fail
 	statusResp, err := c.DataClient.DescribeStatement(ctx, &redshiftdata.DescribeStatementInput{
-		Id: aws.String(output.ID),
+		Id: aws.String(batchID(id)),
 	})
 	if err != nil {
 		return nil, backend.DownstreamError(fmt.Errorf("%w: %v", api.ErrorStatus, err))
 	}
 
-	if statusResp.Error != nil && *statusResp.Error != "" {
-		return nil, backend.DownstreamError(fmt.Errorf("%w: %v", api.ErrorExecute, *statusResp.Error))
-	}
-
 	var finished bool
 	switch statusResp.Status {
 	case redshiftdatatypes.StatusStringFailed,
@@ -166,20 +425,36 @@ func (c *API) Status(ctx context.Context, output *api.ExecuteQueryOutput) (*api.
 		finished = true
 	}
 
-	return &api.ExecuteQueryStatus{
-		ID:       output.ID,
+	status := &api.ExecuteQueryStatus{
+		ID:       id,
 		State:    string(statusResp.Status),
 		Finished: finished,
-	}, nil
+	}
+	if statusResp.Error != nil && *statusResp.Error != "" {
+		return status, backend.DownstreamError(fmt.Errorf("%w: %v", api.ErrorExecute, *statusResp.Error))
+	}
+	return status, nil
 }
 
-func (c *API) CancelQuery(_ context.Context, _ sqlds.Options, queryID string) error {
-	return c.Stop(&api.ExecuteQueryOutput{ID: queryID})
+func (c *API) CancelQuery(ctx context.Context, _ sqlds.Options, queryID string) error {
+	if err := c.CheckOwner(ctx, queryID); err != nil {
+		return err
+	}
+	return c.stop(ctx, &api.ExecuteQueryOutput{ID: queryID})
 }
 
 func (c *API) Stop(output *api.ExecuteQueryOutput) error {
-	_, err := c.DataClient.CancelStatement(context.TODO(), &redshiftdata.CancelStatementInput{
-		Id: &output.ID,
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	return c.stop(ctx, output)
+}
+
+func (c *API) stop(ctx context.Context, output *api.ExecuteQueryOutput) error {
+	if c.scheduler.dequeue(output.ID) {
+		return nil
+	}
+	_, err := c.DataClient.CancelStatement(ctx, &redshiftdata.CancelStatementInput{
+		Id: aws.String(batchID(c.StatementID(output.ID))),
 	})
 	// ignore finished query error
 	if err != nil && !strings.Contains(err.Error(), "Could not cancel a query that is already in FINISHED state") {
@@ -194,7 +469,10 @@ func (c *API) Regions(context.Context) ([]string, error) {
 }
 
 func (c *API) Databases(ctx context.Context, _ sqlds.Options) ([]string, error) {
-	commonInput := c.apiInput()
+	commonInput, err := c.userInput(ctx)
+	if err != nil {
+		return nil, err
+	}
 	input := &redshiftdata.ListDatabasesInput{
 		ClusterIdentifier: commonInput.ClusterIdentifier,
 		Database:          commonInput.Database,
@@ -219,7 +497,10 @@ func (c *API) Databases(ctx context.Context, _ sqlds.Options) ([]string, error)
 }
 
 func (c *API) Schemas(ctx context.Context, _ sqlds.Options) ([]string, error) {
-	commonInput := c.apiInput()
+	commonInput, err := c.userInput(ctx)
+	if err != nil {
+		return nil, err
+	}
 	input := &redshiftdata.ListSchemasInput{
 		ClusterIdentifier: commonInput.ClusterIdentifier,
 		Database:          commonInput.Database,
@@ -249,7 +530,10 @@ func (c *API) Tables(ctx context.Context, options sqlds.Options) ([]string, erro
 	if schema == "" {
 		schema = "public"
 	}
-	commonInput := c.apiInput()
+	commonInput, err := c.userInput(ctx)
+	if err != nil {
+		return nil, err
+	}
 	input := &redshiftdata.ListTablesInput{
 		ClusterIdentifier: commonInput.ClusterIdentifier,
 		Database:          commonInput.Database,
@@ -280,7 +564,10 @@ func (c *API) Tables(ctx context.Context, options sqlds.Options) ([]string, erro
 
 func (c *API) Columns(ctx context.Context, options sqlds.Options) ([]string, error) {
 	schema, table := options["schema"], options["table"]
-	commonInput := c.apiInput()
+	commonInput, err := c.userInput(ctx)
+	if err != nil {
+		return nil, err
+	}
 	input := &redshiftdata.DescribeTableInput{
 		ClusterIdentifier: commonInput.ClusterIdentifier,
 		Database:          commonInput.Database,
@@ -310,16 +597,11 @@ func (c *API) Columns(ctx context.Context, options sqlds.Options) ([]string, err
 	return res, nil
 }
 
+// Secrets lists the secrets that can be selected as managed secret: those matching the discovery
+// filters of the datasource, followed by its extra secrets, which may belong to other accounts
 func (c *API) Secrets(ctx context.Context) ([]models.ManagedSecret, error) {
 	input := &secretsmanager.ListSecretsInput{
-		Filters: []secretsmanagertypes.Filter{
-			{
-				// Only secrets with the tag RedshiftQueryOwner can be used
-				// https://docs.aws.amazon.com/redshift/latest/mgmt/query-editor.html#query-cluster-configure
-				Key:    secretsmanagertypes.FilterNameStringTypeTagKey,
-				Values: []string{"RedshiftQueryOwner"},
-			},
-		},
+		Filters: secretFilters(c.settings),
 	}
 	isFinished := false
 	redshiftSecrets := []models.ManagedSecret{}
@@ -337,12 +619,15 @@ func (c *API) Secrets(ctx context.Context) ([]models.ManagedSecret, error) {
 				continue
 			}
 			redshiftSecrets = append(redshiftSecrets, models.ManagedSecret{
-				ARN:  *secret.ARN,
-				Name: *secret.Name,
+				ARN:             *secret.ARN,
+				Name:            *secret.Name,
+				Description:     aws.ToString(secret.Description),
+				Tags:            secretTags(secret.Tags),
+				LastRotatedDate: secret.LastRotatedDate,
 			})
 		}
 	}
-	return redshiftSecrets, nil
+	return append(redshiftSecrets, c.extraSecrets(ctx, redshiftSecrets)...), nil
 }
 
 func (c *API) Secret(ctx context.Context, options sqlds.Options) (*models.RedshiftSecret, error) {
@@ -354,11 +639,19 @@ func (c *API) Secret(ctx context.Context, options sqlds.Options) (*models.Redshi
 	if err != nil {
 		return nil, err
 	}
-	if out == nil {
+	var content []byte
+	switch {
+	case out == nil:
+		return nil, fmt.Errorf("missing secret content")
+	case out.SecretString != nil:
+		content = []byte(*out.SecretString)
+	case out.SecretBinary != nil:
+		content = out.SecretBinary
+	default:
 		return nil, fmt.Errorf("missing secret content")
 	}
 	res := &models.RedshiftSecret{}
-	err = json.Unmarshal([]byte(*out.SecretString), res)
+	err = json.Unmarshal(content, res)
 	if err != nil {
 		return nil, err
 	}
@@ -10,8 +10,10 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/redshiftdata/types"
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/grafana/grafana-aws-sdk/pkg/sql/api"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/redshift-datasource/pkg/redshift/api/mock"
 	"github.com/grafana/redshift-datasource/pkg/redshift/models"
 	"github.com/grafana/sqlds/v5"
@@ -99,6 +101,35 @@ func Test_apiInput(t *testing.T) {
 	}
 }
 
+func Test_userInput(t *testing.T) {
+	c := &API{settings: &models.RedshiftDataSourceSettings{
+		ClusterIdentifier: "cluster",
+		Database:          "db",
+		DBUser:            "grafana",
+		IdentityMappings: []models.IdentityMapping{
+			{Users: []string{"alice"}, DBUser: "analyst"},
+			{Users: []string{"bob"}, SecretARN: "arn:bob"},
+		},
+	}}
+	input, err := c.userInput(backend.WithUser(context.Background(), &backend.User{Login: "alice"}))
+	require.NoError(t, err)
+	assert.Equal(t, "analyst", aws.ToString(input.DbUser))
+	assert.Nil(t, input.SecretARN)
+
+	input, err = c.userInput(backend.WithUser(context.Background(), &backend.User{Login: "bob"}))
+	require.NoError(t, err)
+	assert.Nil(t, input.DbUser)
+	assert.Equal(t, "arn:bob", aws.ToString(input.SecretARN))
+
+	input, err = c.userInput(backend.WithUser(context.Background(), &backend.User{Login: "carol"}))
+	require.NoError(t, err)
+	assert.Equal(t, "grafana", aws.ToString(input.DbUser))
+
+	c.settings.DenyUnmappedUsers = true
+	_, err = c.Execute(backend.WithUser(context.Background(), &backend.User{Login: "carol"}), &api.ExecuteQueryInput{Query: "select 1"})
+	assert.ErrorIs(t, err, models.ErrUnmappedUser)
+}
+
 func Test_Execute(t *testing.T) {
 	c := &API{
 		settings:   &models.RedshiftDataSourceSettings{},
@@ -114,6 +145,35 @@ func Test_Execute(t *testing.T) {
 	}
 }
 
+func Test_Execute_sessionInitSQL(t *testing.T) {
+	c := &API{
+		settings:   &models.RedshiftDataSourceSettings{SessionInitSQL: "SET search_path TO foo; SET timezone TO 'UTC';"},
+		DataClient: &mock.MockRedshiftClient{ExecutionResult: &redshiftdata.ExecuteStatementOutput{Id: aws.String("foo")}},
+	}
+	res, err := c.Execute(context.Background(), &api.ExecuteQueryInput{Query: "select * from foo"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// the query is the third statement of the batch
+	expectedResult := &api.ExecuteQueryOutput{ID: "foo:3"}
+	if !cmp.Equal(expectedResult, res) {
+		t.Errorf("unexpected result: %v", cmp.Diff(expectedResult, res))
+	}
+	assert.Equal(t, "foo", batchID(res.ID))
+}
+
+func Test_Execute_readOnly(t *testing.T) {
+	client := &mock.MockRedshiftClient{ExecutionResult: &redshiftdata.ExecuteStatementOutput{Id: aws.String("foo")}}
+	c := &API{settings: &models.RedshiftDataSourceSettings{ReadOnly: true}, DataClient: client}
+	_, err := c.Execute(context.Background(), &api.ExecuteQueryInput{Query: "UPDATE foo SET bar = 1"})
+	assert.ErrorContains(t, err, "the datasource is read-only: UPDATE statements are not allowed")
+	assert.True(t, backend.IsDownstreamError(err))
+
+	res, err := c.Execute(context.Background(), &api.ExecuteQueryInput{Query: "select * from foo"})
+	require.NoError(t, err)
+	assert.Equal(t, "foo", res.ID)
+}
+
 func Test_Status(t *testing.T) {
 	tests := []struct {
 		description string
@@ -161,6 +221,40 @@ func Test_Status(t *testing.T) {
 	}
 }
 
+func Test_CheckOwner(t *testing.T) {
+	settings := &models.RedshiftDataSourceSettings{
+		ClusterIdentifier: "cluster",
+		Database:          "db",
+		DBUser:            "grafana",
+		IdentityMappings:  []models.IdentityMapping{{Users: []string{"alice"}, DBUser: "analyst"}},
+	}
+	alice := backend.WithUser(context.Background(), &backend.User{Login: "alice"})
+	bob := backend.WithUser(context.Background(), &backend.User{Login: "bob"})
+	client := &mock.MockRedshiftClient{
+		ExecutionResult:         &redshiftdata.ExecuteStatementOutput{Id: aws.String("foo")},
+		DescribeStatementOutput: &redshiftdata.DescribeStatementOutput{Id: aws.String("foo"), Status: types.StatusStringFinished},
+	}
+	c := &API{settings: settings, DataClient: client, scheduler: newScheduler(10), owners: newStatementLog()}
+
+	res, err := c.Execute(alice, &api.ExecuteQueryInput{Query: "select 1"})
+	require.NoError(t, err)
+	_, err = c.Status(alice, res)
+	assert.NoError(t, err)
+	assert.NoError(t, c.CheckOwner(alice, res.ID))
+	_, err = c.Status(bob, res)
+	assert.ErrorIs(t, err, errNotOwner)
+	assert.ErrorIs(t, c.CheckOwner(bob, res.ID), errNotOwner)
+	assert.ErrorIs(t, c.CancelQuery(bob, sqlds.Options{}, res.ID), errNotOwner)
+
+	// queries the datasource did not run are rejected
+	assert.ErrorIs(t, c.CheckOwner(alice, "bar"), errNotOwner)
+
+	// without identity mappings, every user runs queries with the same identity
+	c = &API{settings: &models.RedshiftDataSourceSettings{DBUser: "grafana"}, DataClient: client, owners: newStatementLog()}
+	_, err = c.Status(bob, &api.ExecuteQueryOutput{ID: "bar"})
+	assert.NoError(t, err)
+}
+
 func Test_ListSchemas(t *testing.T) {
 	resources := map[string]map[string][]string{
 		"foo": {},
@@ -231,7 +325,7 @@ func Test_ListColumns(t *testing.T) {
 }
 func Test_ListSecrets(t *testing.T) {
 	expectedSecrets := []models.ManagedSecret{{Name: "foo", ARN: "arn:foo"}}
-	c := &API{SecretsClient: &mock.MockRedshiftSecretsManager{Secrets: []string{"foo"}}}
+	c := &API{settings: &models.RedshiftDataSourceSettings{}, SecretsClient: &mock.MockRedshiftSecretsManager{Secrets: []string{"foo"}}}
 	secrets, err := c.Secrets(context.Background())
 	if err != nil {
 		t.Fatalf("unexpected error %v", err)
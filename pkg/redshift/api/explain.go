@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftdata"
+	redshiftdatatypes "github.com/aws/aws-sdk-go-v2/service/redshiftdata/types"
+	"github.com/grafana/grafana-aws-sdk/pkg/sql/api"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+// Explain runs EXPLAIN on the query, which must already have its macros expanded, and returns the parsed plan
+func (c *API) Explain(ctx context.Context, query string) (*models.QueryPlan, error) {
+	output, err := c.Execute(ctx, &api.ExecuteQueryInput{Query: "EXPLAIN " + query})
+	if err != nil {
+		return nil, err
+	}
+	if err := api.WaitOnQuery(ctx, c, output); err != nil {
+		return nil, err
+	}
+	lines := []string{}
+	input := &redshiftdata.GetStatementResultInput{Id: aws.String(c.StatementID(output.ID))}
+	for {
+		res, err := c.DataClient.GetStatementResult(ctx, input)
+		if err != nil {
+			return nil, backend.DownstreamError(err)
+		}
+		for _, record := range res.Records {
+			if len(record) == 0 {
+				continue
+			}
+			field, ok := record[0].(*redshiftdatatypes.FieldMemberStringValue)
+			if !ok {
+				return nil, fmt.Errorf("unexpected EXPLAIN result %T", record[0])
+			}
+			lines = append(lines, field.Value)
+		}
+		input.NextToken = res.NextToken
+		if input.NextToken == nil {
+			break
+		}
+	}
+	plan, err := models.ParsePlan(lines)
+	if err != nil {
+		return nil, backend.DownstreamError(err)
+	}
+	return plan, nil
+}
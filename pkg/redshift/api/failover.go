@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+// statementLogTTL is how long the target that ran a statement and the identity it ran with are
+// remembered, for the requests reading its result
+const statementLogTTL = time.Hour
+
+// target is a cluster or workgroup statements can run on
+type target struct {
+	name  string
+	input apiInput
+}
+
+// targets returns the target of the datasource followed by its failover targets. The queries of users
+// mapped to their own database identity run with it on every target, those of other users with the
+// identity of each target. With identity propagation, failover targets have no identity of their own.
+func (c *API) targets(ctx context.Context) ([]target, error) {
+	primary, err := c.userInput(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var mapping *models.IdentityMapping
+	if c.settings.IdentityPropagation == "" {
+		// already checked by userInput
+		mapping, _ = c.settings.UserIdentity(backend.UserFromContext(ctx))
+	}
+	name := models.Target{ClusterIdentifier: aws.ToString(primary.ClusterIdentifier), WorkgroupName: aws.ToString(primary.WorkgroupName)}.Name()
+	res := []target{{name: name, input: primary}}
+	for _, t := range c.settings.FailoverTargets {
+		input := apiInput{Database: aws.String(c.settings.Database), User: primary.User}
+		if t.Database != "" {
+			input.Database = aws.String(t.Database)
+		}
+		if t.WorkgroupName != "" {
+			input.WorkgroupName = aws.String(t.WorkgroupName)
+		} else {
+			input.ClusterIdentifier = aws.String(t.ClusterIdentifier)
+		}
+		switch {
+		case mapping != nil:
+			input.DbUser, input.SecretARN = primary.DbUser, primary.SecretARN
+		case t.SecretARN != "":
+			input.SecretARN = aws.String(t.SecretARN)
+		case t.DBUser != "":
+			input.DbUser = aws.String(t.DBUser)
+		}
+		res = append(res, target{name: t.Name(), input: input})
+	}
+	return res, nil
+}
+
+// isFailoverError returns true if the statement could not be submitted because the target is paused,
+// unavailable or throttled, so that it may run on another target
+func isFailoverError(err error) bool {
+	if isThrottlingError(err) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "ActiveStatementsExceededException" {
+		return true
+	}
+	return isUnavailableError(err)
+}
+
+// isUnavailableError returns true if the error says the target is paused, resuming or unavailable.
+// The Data API says that the endpoint of a paused cluster does not exist.
+func isUnavailableError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, reason := range []string{"paused", "resuming", "not available", "unavailable", "endpoint doesn't exist"} {
+		if strings.Contains(msg, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// servedBy remembers the target that ran a statement of a datasource with failover targets
+func (c *API) servedBy(ctx context.Context, id, name string) {
+	if len(c.settings.FailoverTargets) == 0 || name == "" {
+		return
+	}
+	c.served.add(id, name)
+	if recorder, ok := ctx.Value(targetRecorderKey{}).(*targetRecorder); ok {
+		recorder.set(name)
+	}
+}
+
+type loggedValue struct {
+	value   string
+	expires time.Time
+}
+
+// statementLog maps statements to a value, e.g. the target that ran them
+type statementLog struct {
+	mu      sync.Mutex
+	entries map[string]loggedValue
+}
+
+func newStatementLog() *statementLog {
+	return &statementLog{entries: map[string]loggedValue{}}
+}
+
+func (l *statementLog) add(id, value string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for k, v := range l.entries {
+		if now.After(v.expires) {
+			delete(l.entries, k)
+		}
+	}
+	l.entries[id] = loggedValue{value: value, expires: now.Add(statementLogTTL)}
+}
+
+// lookup returns the value of a statement, ok is false if it is unknown
+func (l *statementLog) lookup(id string) (value string, ok bool) {
+	if l == nil {
+		return "", false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v, ok := l.entries[id]
+	if !ok || time.Now().After(v.expires) {
+		return "", false
+	}
+	return v.value, true
+}
+
+func (l *statementLog) get(id string) string {
+	value, _ := l.lookup(id)
+	return value
+}
+
+type targetRecorderKey struct{}
+
+type targetRecorder struct {
+	mu   sync.Mutex
+	name string
+}
+
+func (r *targetRecorder) set(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.name = name
+}
+
+func (r *targetRecorder) get() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.name
+}
+
+// WithTargetRecorder returns a context in which the target that runs a query is recorded, and a
+// function returning that target, which is empty if the datasource has no failover targets
+func WithTargetRecorder(ctx context.Context) (context.Context, func() string) {
+	recorder := &targetRecorder{}
+	return context.WithValue(ctx, targetRecorderKey{}, recorder), recorder.get
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftdata"
+	redshiftdatatypes "github.com/aws/aws-sdk-go-v2/service/redshiftdata/types"
+	"github.com/aws/smithy-go"
+	"github.com/grafana/grafana-aws-sdk/pkg/sql/api"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/api/mock"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+// failingTargetsClient fails statements submitted to the given targets, the others finish right away
+type failingTargetsClient struct {
+	mock.MockRedshiftClient
+	mu        sync.Mutex
+	errs      map[string]error
+	submitted []string
+}
+
+func (c *failingTargetsClient) ExecuteStatement(_ context.Context, input *redshiftdata.ExecuteStatementInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.ExecuteStatementOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	target := aws.ToString(input.ClusterIdentifier) + aws.ToString(input.WorkgroupName)
+	c.submitted = append(c.submitted, target)
+	if err := c.errs[target]; err != nil {
+		return nil, err
+	}
+	return &redshiftdata.ExecuteStatementOutput{Id: aws.String(target + "-statement")}, nil
+}
+
+func (c *failingTargetsClient) DescribeStatement(_ context.Context, input *redshiftdata.DescribeStatementInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.DescribeStatementOutput, error) {
+	return &redshiftdata.DescribeStatementOutput{Id: input.Id, Status: redshiftdatatypes.StatusStringFinished}, nil
+}
+
+func Test_Execute_failover(t *testing.T) {
+	settings := &models.RedshiftDataSourceSettings{
+		Config:            backend.DataSourceInstanceSettings{ID: 44},
+		ClusterIdentifier: "nightly",
+		DBUser:            "grafana",
+		Database:          "dev",
+		FailoverTargets: []models.Target{
+			{ClusterIdentifier: "busy", DBUser: "grafana"},
+			{WorkgroupName: "serverless", SecretARN: "arn:secret"},
+		},
+	}
+	client := &failingTargetsClient{errs: map[string]error{
+		"nightly": errors.New("ValidationException: Cluster nightly is paused"),
+		"busy":    &smithy.GenericAPIError{Code: "ActiveStatementsExceededException", Message: "too many statements"},
+	}}
+	c := &API{settings: settings, DataClient: client, ManagementClient: &mock.MockRedshiftClient{}, served: newStatementLog()}
+
+	ctx, served := WithTargetRecorder(context.Background())
+	res, err := c.Execute(ctx, &api.ExecuteQueryInput{Query: "select 1"})
+	require.NoError(t, err)
+	assert.Equal(t, "serverless-statement", res.ID)
+	assert.Equal(t, []string{"nightly", "busy", "serverless"}, client.submitted)
+	assert.Equal(t, "workgroup/serverless", served())
+	assert.Equal(t, "workgroup/serverless", c.served.get(res.ID))
+
+	// other errors do not fail over
+	client.errs["nightly"] = errors.New("ValidationException: syntax error")
+	client.submitted = nil
+	_, err = c.Execute(context.Background(), &api.ExecuteQueryInput{Query: "select 2"})
+	assert.ErrorContains(t, err, "syntax error")
+	assert.Equal(t, []string{"nightly"}, client.submitted)
+}
+
+func Test_targets_identityMapping(t *testing.T) {
+	settings := &models.RedshiftDataSourceSettings{
+		ClusterIdentifier: "primary",
+		DBUser:            "grafana",
+		Database:          "dev",
+		IdentityMappings:  []models.IdentityMapping{{Users: []string{"alice"}, SecretARN: "arn:alice"}},
+		FailoverTargets: []models.Target{
+			{ClusterIdentifier: "dr", DBUser: "admin"},
+			{WorkgroupName: "serverless", SecretARN: "arn:admin"},
+		},
+	}
+	c := &API{settings: settings}
+
+	// mapped users run with their own identity on every target
+	targets, err := c.targets(backend.WithUser(context.Background(), &backend.User{Login: "alice"}))
+	require.NoError(t, err)
+	require.Len(t, targets, 3)
+	for _, target := range targets {
+		assert.Nil(t, target.input.DbUser, target.name)
+		assert.Equal(t, "arn:alice", aws.ToString(target.input.SecretARN), target.name)
+	}
+
+	// others with the identity of each target
+	targets, err = c.targets(backend.WithUser(context.Background(), &backend.User{Login: "bob"}))
+	require.NoError(t, err)
+	assert.Equal(t, "grafana", aws.ToString(targets[0].input.DbUser))
+	assert.Equal(t, "admin", aws.ToString(targets[1].input.DbUser))
+	assert.Equal(t, "arn:admin", aws.ToString(targets[2].input.SecretARN))
+}
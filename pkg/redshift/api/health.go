@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftserverless"
+	redshiftserverlesstypes "github.com/aws/aws-sdk-go-v2/service/redshiftserverless/types"
+	"github.com/grafana/grafana-aws-sdk/pkg/sql/api"
+	"github.com/grafana/sqlds/v5"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+// Steps of the health check
+const (
+	healthStepCredentials = "credentials"
+	healthStepTarget      = "target"
+	healthStepQuery       = "query"
+	healthStepCatalog     = "catalog"
+	healthStepSecret      = "secret"
+)
+
+const healthCheckQuery = "SELECT 1"
+
+// healthWarning is returned by steps that could not check what they check, see models.HealthStepWarning
+type healthWarning struct {
+	error
+}
+
+// CheckHealth checks, one step after the other, that the credentials of the datasource resolve, that its
+// target exists and is available, that a test query runs to completion, that the catalog can be listed and
+// that the managed secret can be read. Steps that depend on a failed one are skipped. The test query always
+// runs, since it is what queries need, and the target step is only a warning when its permissions are missing.
+func (c *API) CheckHealth(ctx context.Context) *models.HealthReport {
+	report := &models.HealthReport{}
+	run := func(name string, skip string, fn func() (string, error)) {
+		step := models.HealthStep{Name: name}
+		if skip != "" {
+			step.Status, step.Message = models.HealthStepSkipped, skip
+			report.Steps = append(report.Steps, step)
+			return
+		}
+		start := time.Now()
+		msg, err := fn()
+		step.DurationMs = time.Since(start).Milliseconds()
+		var warning healthWarning
+		if errors.As(err, &warning) {
+			step.Status, step.Message = models.HealthStepWarning, err.Error()
+		} else if err != nil {
+			step.Status, step.Message = models.HealthStepError, err.Error()
+		} else {
+			step.Status, step.Message = models.HealthStepOK, msg
+		}
+		report.Steps = append(report.Steps, step)
+	}
+	skipIf := func(failed bool, msg string) string {
+		if failed {
+			return msg
+		}
+		return ""
+	}
+
+	run(healthStepCredentials, "", func() (string, error) { return c.checkCredentials(ctx) })
+	noCredentials := report.Failed(healthStepCredentials)
+	run(healthStepTarget, skipIf(noCredentials, "requires valid credentials"), func() (string, error) { return c.checkTarget(ctx) })
+	run(healthStepQuery, "", func() (string, error) { return c.checkQuery(ctx) })
+	run(healthStepCatalog, skipIf(report.Failed(healthStepQuery), "requires the test query to run"), func() (string, error) { return c.checkCatalog(ctx) })
+	secretSkip := skipIf(noCredentials, "requires valid credentials")
+	if !c.settings.UseManagedSecret {
+		secretSkip = "the datasource does not use a managed secret"
+	}
+	run(healthStepSecret, secretSkip, func() (string, error) { return c.checkSecret(ctx) })
+	return report
+}
+
+func (c *API) checkCredentials(ctx context.Context) (string, error) {
+	if c.credentials == nil {
+		return "", fmt.Errorf("no credentials provider")
+	}
+	creds, err := c.credentials.Retrieve(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("credentials resolved from %s", creds.Source), nil
+}
+
+// checkTarget describes the cluster or workgroup of the datasource. Describing them needs permissions that
+// running queries does not, so the step is a warning when they are missing.
+func (c *API) checkTarget(ctx context.Context) (string, error) {
+	msg, err := c.describeTarget(ctx)
+	if err != nil && isAccessDenied(err) {
+		return "", healthWarning{fmt.Errorf("the availability of the target could not be checked: %w", err)}
+	}
+	return msg, err
+}
+
+func (c *API) describeTarget(ctx context.Context) (string, error) {
+	if c.settings.UseServerless {
+		out, err := c.ServerlessManagementClient.GetWorkgroup(ctx, &redshiftserverless.GetWorkgroupInput{WorkgroupName: aws.String(c.settings.WorkgroupName)})
+		if err != nil {
+			return "", err
+		}
+		if out == nil || out.Workgroup == nil {
+			return "", fmt.Errorf("workgroup %s not found", c.settings.WorkgroupName)
+		}
+		if out.Workgroup.Status != redshiftserverlesstypes.WorkgroupStatusAvailable {
+			return "", fmt.Errorf("workgroup %s is %s", c.settings.WorkgroupName, out.Workgroup.Status)
+		}
+		return fmt.Sprintf("workgroup %s is available", c.settings.WorkgroupName), nil
+	}
+	status, err := c.clusterStatus(ctx, c.settings.ClusterIdentifier)
+	if err != nil {
+		return "", err
+	}
+	if status != clusterAvailable {
+		return "", fmt.Errorf("cluster %s is %s", c.settings.ClusterIdentifier, status)
+	}
+	return fmt.Sprintf("cluster %s is available", c.settings.ClusterIdentifier), nil
+}
+
+// checkQuery runs the test query and waits for it to finish. It bypasses the result cache and the
+// deduplication of queries, but not the datasource scheduler.
+func (c *API) checkQuery(ctx context.Context) (string, error) {
+	id, err := c.scheduler.execute(ctx, c, "health/"+newTicket(), func(ctx context.Context) (string, error) {
+		return c.executeStatement(ctx, &api.ExecuteQueryInput{Query: healthCheckQuery})
+	})
+	if err != nil {
+		return "", err
+	}
+	if input, err := c.userInput(ctx); err == nil {
+		c.ownedBy(id, input)
+	}
+	if err := api.WaitOnQuery(ctx, c, &api.ExecuteQueryOutput{ID: id}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s finished", healthCheckQuery), nil
+}
+
+func (c *API) checkCatalog(ctx context.Context) (string, error) {
+	schemas, err := c.Schemas(ctx, sqlds.Options{})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d schemas listed", len(schemas)), nil
+}
+
+func (c *API) checkSecret(ctx context.Context) (string, error) {
+	secret, err := c.Secret(ctx, sqlds.Options{"secretARN": c.secretARN()})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("secret of the database user %s is readable", secret.DBUser), nil
+}
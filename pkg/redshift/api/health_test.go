@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftdata"
+	redshiftdatatypes "github.com/aws/aws-sdk-go-v2/service/redshiftdata/types"
+	"github.com/aws/smithy-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/api/mock"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+func Test_CheckHealth(t *testing.T) {
+	newAPI := func(status string, credsErr error) *API {
+		return &API{
+			settings: &models.RedshiftDataSourceSettings{
+				Config:            backend.DataSourceInstanceSettings{ID: 49},
+				ClusterIdentifier: "nightly",
+				DBUser:            "grafana",
+				Database:          "dev",
+			},
+			DataClient: &mock.MockRedshiftClient{
+				ExecutionResult:         &redshiftdata.ExecuteStatementOutput{Id: aws.String("statement")},
+				DescribeStatementOutput: &redshiftdata.DescribeStatementOutput{Status: redshiftdatatypes.StatusStringFinished},
+				Resources:               map[string]map[string][]string{"public": {}},
+			},
+			ManagementClient: &fakeManagementClient{status: status},
+			scheduler:        newScheduler(1),
+			credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+				return aws.Credentials{Source: "test"}, credsErr
+			}),
+		}
+	}
+	statuses := func(report *models.HealthReport) map[string]string {
+		res := map[string]string{}
+		for _, step := range report.Steps {
+			res[step.Name] = step.Status
+		}
+		return res
+	}
+
+	report := newAPI(clusterAvailable, nil).CheckHealth(context.Background())
+	assert.NoError(t, report.Err())
+	assert.Equal(t, map[string]string{
+		"credentials": models.HealthStepOK,
+		"target":      models.HealthStepOK,
+		"query":       models.HealthStepOK,
+		"catalog":     models.HealthStepOK,
+		"secret":      models.HealthStepSkipped,
+	}, statuses(report))
+	assert.Equal(t, "credentials resolved from test", report.Steps[0].Message)
+
+	// the test query runs even if the target is not available
+	report = newAPI(clusterPaused, nil).CheckHealth(context.Background())
+	assert.EqualError(t, report.Err(), "target check failed: cluster nightly is paused")
+	assert.Equal(t, models.HealthStepOK, statuses(report)["query"])
+
+	// the target may not be described for lack of permissions, which queries do not need
+	c := newAPI(clusterAvailable, nil)
+	c.ManagementClient = &fakeManagementClient{err: &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform: redshift:DescribeClusters"}}
+	report = c.CheckHealth(context.Background())
+	assert.NoError(t, report.Err())
+	assert.Equal(t, models.HealthStepWarning, statuses(report)["target"])
+	assert.Contains(t, report.Steps[1].Message, "the availability of the target could not be checked")
+	assert.Equal(t, models.HealthStepOK, statuses(report)["query"])
+	assert.Equal(t, models.HealthStepOK, statuses(report)["catalog"])
+
+	// the catalog is not listed if the test query fails
+	c = newAPI(clusterAvailable, nil)
+	c.DataClient.(*mock.MockRedshiftClient).DescribeStatementOutput = &redshiftdata.DescribeStatementOutput{Status: redshiftdatatypes.StatusStringFailed, Error: aws.String("permission denied")}
+	report = c.CheckHealth(context.Background())
+	assert.EqualError(t, report.Err(), "query check failed: "+report.Steps[2].Message)
+	assert.Equal(t, models.HealthStepSkipped, statuses(report)["catalog"])
+
+	report = newAPI(clusterAvailable, errors.New("no EC2 IMDS role found")).CheckHealth(context.Background())
+	assert.EqualError(t, report.Err(), "credentials check failed: no EC2 IMDS role found")
+	assert.Equal(t, models.HealthStepSkipped, statuses(report)["target"])
+}
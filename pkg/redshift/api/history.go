@@ -0,0 +1,234 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftdata"
+	redshiftdatatypes "github.com/aws/aws-sdk-go-v2/service/redshiftdata/types"
+	"github.com/grafana/grafana-aws-sdk/pkg/sql/api"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/sqlds/v5"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+	// bounds the statements paged through, since the time window is filtered locally
+	maxHistoryPages = 20
+	// statements described at the same time to get their details
+	historyDescribeConcurrency = 10
+)
+
+// historyFilter selects the statements returned by History
+type historyFilter struct {
+	status  redshiftdatatypes.StatusString
+	running bool
+	from    time.Time
+	to      time.Time
+	tag     string
+	limit   int
+}
+
+func parseHistoryFilter(options sqlds.Options) (historyFilter, error) {
+	filter := historyFilter{limit: defaultHistoryLimit}
+	if status := strings.ToUpper(options["status"]); status != "" && status != string(redshiftdatatypes.StatusStringAll) {
+		filter.status = redshiftdatatypes.StatusString(status)
+	}
+	for key, t := range map[string]*time.Time{"from": &filter.from, "to": &filter.to} {
+		if options[key] == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, options[key])
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s time %q, expected RFC 3339", key, options[key])
+		}
+		*t = parsed
+	}
+	filter.tag = options["tag"]
+	if options["limit"] != "" {
+		limit, err := strconv.Atoi(options["limit"])
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit %q", options["limit"])
+		}
+		filter.limit = min(limit, maxHistoryLimit)
+	}
+	return filter, nil
+}
+
+func (f historyFilter) matches(statement redshiftdatatypes.StatementData) bool {
+	if f.running && !isRunning(statement.Status) {
+		return false
+	}
+	createdAt := aws.ToTime(statement.CreatedAt)
+	if !f.from.IsZero() && createdAt.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && createdAt.After(f.to) {
+		return false
+	}
+	return f.tag == "" || hasTags(aws.ToString(statement.StatementName), f.tag)
+}
+
+// hasTags returns true if the statement name has every one of the comma-separated key=value tags.
+// The tags of a name follow its last colon, see tagStatement.
+func hasTags(name, tags string) bool {
+	nameTags := strings.Split(name[strings.LastIndex(name, ":")+1:], ",")
+	for _, tag := range strings.Split(tags, ",") {
+		if !slices.Contains(nameTags, strings.TrimSpace(tag)) {
+			return false
+		}
+	}
+	return true
+}
+
+// History returns the statements recently run by the datasource, most recent first. Statements are
+// found by the name they are given by the datasource and can be filtered by status, creation time
+// and tag (e.g. "dashboardUID=abc"). Their duration, rows and error are described one by one.
+func (c *API) History(ctx context.Context, options sqlds.Options) ([]models.RedshiftStatement, error) {
+	filter, err := parseHistoryFilter(options)
+	if err != nil {
+		return nil, err
+	}
+	statements, err := c.listStatements(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	c.describeStatements(ctx, statements)
+	return statements, nil
+}
+
+// listStatements pages through the statements of the datasource until enough match the filter
+func (c *API) listStatements(ctx context.Context, filter historyFilter) ([]models.RedshiftStatement, error) {
+	input := &redshiftdata.ListStatementsInput{
+		StatementName: aws.String(StatementNamePrefix(c.settings.Config.UID)),
+		Status:        filter.status,
+		MaxResults:    aws.Int32(100),
+	}
+	res := []models.RedshiftStatement{}
+	for page := 0; page < maxHistoryPages; page++ {
+		out, err := c.DataClient.ListStatements(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, statement := range out.Statements {
+			if !filter.matches(statement) {
+				continue
+			}
+			query := aws.ToString(statement.QueryString)
+			if len(statement.QueryStrings) > 0 {
+				// session initialization statements come first in a batch
+				query = statement.QueryStrings[len(statement.QueryStrings)-1]
+			}
+			id := aws.ToString(statement.Id)
+			if aws.ToBool(statement.IsBatchStatement) {
+				id = fmt.Sprintf("%s:%d", id, len(statement.QueryStrings))
+			}
+			res = append(res, models.RedshiftStatement{
+				ID:        id,
+				Name:      aws.ToString(statement.StatementName),
+				Query:     query,
+				Status:    string(statement.Status),
+				CreatedAt: aws.ToTime(statement.CreatedAt),
+				UpdatedAt: aws.ToTime(statement.UpdatedAt),
+			})
+		}
+		input.NextToken = out.NextToken
+		if input.NextToken == nil {
+			break
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].CreatedAt.After(res[j].CreatedAt)
+	})
+	if len(res) > filter.limit {
+		res = res[:filter.limit]
+	}
+	return res, nil
+}
+
+// describeStatements fills in the duration, rows and error of the statements
+func (c *API) describeStatements(ctx context.Context, statements []models.RedshiftStatement) {
+	sem := make(chan struct{}, historyDescribeConcurrency)
+	var wg sync.WaitGroup
+	for i := range statements {
+		wg.Add(1)
+		go func(statement *models.RedshiftStatement) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			out, err := c.DataClient.DescribeStatement(ctx, &redshiftdata.DescribeStatementInput{Id: aws.String(statement.ID)})
+			if err != nil {
+				backend.Logger.Debug("failed to describe statement", "id", statement.ID, "error", err)
+				return
+			}
+			if out.Duration > 0 {
+				statement.DurationMs = time.Duration(out.Duration).Milliseconds()
+			}
+			statement.Rows = out.ResultRows
+			statement.Error = aws.ToString(out.Error)
+		}(&statements[i])
+	}
+	wg.Wait()
+}
+
+func isRunning(status redshiftdatatypes.StatusString) bool {
+	switch status {
+	case redshiftdatatypes.StatusStringSubmitted,
+		redshiftdatatypes.StatusStringPicked,
+		redshiftdatatypes.StatusStringStarted:
+		return true
+	}
+	return false
+}
+
+// Running returns the statements of the datasource that are still running, optionally filtered by
+// tag, with the time they have been running for
+func (c *API) Running(ctx context.Context, options sqlds.Options) ([]models.RedshiftStatement, error) {
+	statements, err := c.listStatements(ctx, historyFilter{running: true, tag: options["tag"], limit: maxHistoryLimit})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range statements {
+		statements[i].DurationMs = now.Sub(statements[i].CreatedAt).Milliseconds()
+	}
+	return statements, nil
+}
+
+// CancelStatements cancels running statements of the datasource: the one with the given "id", those
+// with the given "tag" (e.g. "dashboardUID=abc") or, if "all" is "true", every one of them
+func (c *API) CancelStatements(ctx context.Context, options sqlds.Options) (*models.CancelResult, error) {
+	id, tag, all := options["id"], options["tag"], options["all"] == "true"
+	if id == "" && tag == "" && !all {
+		return nil, fmt.Errorf("missing statements to cancel: set an id, a tag or all")
+	}
+	running, err := c.Running(ctx, sqlds.Options{"tag": tag})
+	if err != nil {
+		return nil, err
+	}
+	res := &models.CancelResult{Canceled: []string{}, Failed: map[string]string{}}
+	for _, statement := range running {
+		if id != "" && statement.ID != id && batchID(statement.ID) != id {
+			continue
+		}
+		if err := c.stop(ctx, &api.ExecuteQueryOutput{ID: statement.ID}); err != nil {
+			res.Failed[statement.ID] = err.Error()
+			continue
+		}
+		res.Canceled = append(res.Canceled, statement.ID)
+	}
+	if id != "" && len(res.Canceled) == 0 && len(res.Failed) == 0 {
+		return nil, fmt.Errorf("statement %s is not running or was not run by this datasource", id)
+	}
+	return res, nil
+}
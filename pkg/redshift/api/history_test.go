@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftdata"
+	redshiftdatatypes "github.com/aws/aws-sdk-go-v2/service/redshiftdata/types"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/sqlds/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/api/mock"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+func Test_History(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &API{
+		settings: &models.RedshiftDataSourceSettings{Config: backend.DataSourceInstanceSettings{UID: "uid"}},
+		DataClient: &mock.MockRedshiftClient{
+			ListStatementsOutput: &redshiftdata.ListStatementsOutput{
+				Statements: []redshiftdatatypes.StatementData{
+					{Id: aws.String("old"), StatementName: aws.String("grafana:uid:panelID=1"), QueryString: aws.String("select 1"), CreatedAt: aws.Time(start)},
+					{Id: aws.String("other"), StatementName: aws.String("grafana:uid:panelID=2"), QueryString: aws.String("select 2"), CreatedAt: aws.Time(start.Add(2 * time.Hour))},
+					{
+						Id:               aws.String("batch"),
+						StatementName:    aws.String("grafana:uid:panelID=1"),
+						IsBatchStatement: aws.Bool(true),
+						QueryStrings:     []string{"SET search_path TO foo", "select 3"},
+						Status:           redshiftdatatypes.StatusStringFailed,
+						CreatedAt:        aws.Time(start.Add(2 * time.Hour)),
+					},
+				},
+			},
+			DescribeStatementOutput: &redshiftdata.DescribeStatementOutput{Duration: int64(2 * time.Second), ResultRows: 3, Error: aws.String("boom")},
+		},
+	}
+	res, err := c.History(context.Background(), sqlds.Options{"from": start.Add(time.Hour).Format(time.RFC3339), "tag": "panelID=1"})
+	require.NoError(t, err)
+	assert.Equal(t, []models.RedshiftStatement{{
+		ID:         "batch:2",
+		Name:       "grafana:uid:panelID=1",
+		Query:      "select 3",
+		Status:     "FAILED",
+		CreatedAt:  start.Add(2 * time.Hour),
+		DurationMs: 2000,
+		Rows:       3,
+		Error:      "boom",
+	}}, res)
+
+	_, err = c.History(context.Background(), sqlds.Options{"limit": "none"})
+	assert.Error(t, err)
+}
+
+type cancelClient struct {
+	mock.MockRedshiftClient
+	canceled []string
+}
+
+func (c *cancelClient) CancelStatement(_ context.Context, input *redshiftdata.CancelStatementInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.CancelStatementOutput, error) {
+	c.canceled = append(c.canceled, *input.Id)
+	return &redshiftdata.CancelStatementOutput{Status: aws.Bool(true)}, nil
+}
+
+func Test_CancelStatements(t *testing.T) {
+	client := &cancelClient{MockRedshiftClient: mock.MockRedshiftClient{
+		ListStatementsOutput: &redshiftdata.ListStatementsOutput{
+			Statements: []redshiftdatatypes.StatementData{
+				{Id: aws.String("a"), StatementName: aws.String("grafana:uid:dashboardUID=abc"), Status: redshiftdatatypes.StatusStringStarted},
+				{Id: aws.String("b"), StatementName: aws.String("grafana:uid:dashboardUID=abc"), Status: redshiftdatatypes.StatusStringFinished},
+				{Id: aws.String("c"), StatementName: aws.String("grafana:uid:dashboardUID=def"), Status: redshiftdatatypes.StatusStringSubmitted},
+			},
+		},
+	}}
+	c := &API{
+		settings:   &models.RedshiftDataSourceSettings{Config: backend.DataSourceInstanceSettings{UID: "uid"}},
+		DataClient: client,
+	}
+
+	res, err := c.CancelStatements(context.Background(), sqlds.Options{"tag": "dashboardUID=abc"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, res.Canceled)
+
+	_, err = c.CancelStatements(context.Background(), sqlds.Options{"id": "b"})
+	assert.Error(t, err)
+
+	res, err = c.CancelStatements(context.Background(), sqlds.Options{"all": "true"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "c"}, res.Canceled)
+	assert.Len(t, client.canceled, 3)
+
+	_, err = c.CancelStatements(context.Background(), sqlds.Options{})
+	assert.Error(t, err)
+}
+
+func Test_hasTags(t *testing.T) {
+	name := "grafana:uid:dashboardUID=abc,panelID=1,user=admin"
+	for tags, expected := range map[string]bool{
+		"dashboardUID=abc":          true,
+		"panelID=1,user=admin":      true,
+		"dashboardUID=ab":           false,
+		"dashboardUID=abcd":         false,
+		"panelID=12":                false,
+		"UID=abc":                   false,
+		"user=admin,panelID=2":      false,
+		"dashboardUID=abc,panelID=": false,
+	} {
+		assert.Equal(t, expected, hasTags(name, tags), tags)
+	}
+}
+
+func Test_CancelStatements_collidingTags(t *testing.T) {
+	client := &cancelClient{MockRedshiftClient: mock.MockRedshiftClient{
+		ListStatementsOutput: &redshiftdata.ListStatementsOutput{
+			Statements: []redshiftdatatypes.StatementData{
+				{Id: aws.String("a"), StatementName: aws.String("grafana:uid:dashboardUID=abc,panelID=1"), Status: redshiftdatatypes.StatusStringStarted},
+				{Id: aws.String("b"), StatementName: aws.String("grafana:uid:dashboardUID=abcd,panelID=1"), Status: redshiftdatatypes.StatusStringStarted},
+				{Id: aws.String("c"), StatementName: aws.String("grafana:uid:dashboardUID=xabc,panelID=12"), Status: redshiftdatatypes.StatusStringStarted},
+			},
+		},
+	}}
+	c := &API{
+		settings:   &models.RedshiftDataSourceSettings{Config: backend.DataSourceInstanceSettings{UID: "uid"}},
+		DataClient: client,
+	}
+
+	res, err := c.CancelStatements(context.Background(), sqlds.Options{"tag": "dashboardUID=abc"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, res.Canceled)
+	assert.Equal(t, []string{"a"}, client.canceled)
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/api/types"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+const (
+	identityCenterContextProvider = "arn:aws:iam::aws:contextProvider/IdentityCenter"
+	identityContextClaim          = "sts:identity_context"
+	jwtBearerGrant                = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	// credentials are renewed this long before they expire
+	identityCredentialsExpiryWindow = time.Minute
+	maxIdentityCredentials          = 1000
+)
+
+var ErrMissingIdentityToken = errors.New("identity propagation is enabled but Grafana did not forward the OAuth identity of the user, sign in with OAuth and enable forwarding the OAuth identity in the datasource settings")
+
+type identityTokenKey struct{}
+
+// WithIdentityToken returns a context carrying the OAuth token of the user, exchanged for the
+// credentials of Data API calls when identity propagation is enabled
+func WithIdentityToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, identityTokenKey{}, token)
+}
+
+func identityTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(identityTokenKey{}).(string)
+	return token
+}
+
+// IdentityToken returns the token forwarded by Grafana that identifies the user: the OIDC ID token
+// if there is one, the access token of the Authorization header otherwise
+func IdentityToken(idToken, authorization string) string {
+	if idToken != "" {
+		return idToken
+	}
+	if token, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		return token
+	}
+	return ""
+}
+
+// identityCredentials provides the credentials of the user whose token is in the context of a call
+type identityCredentials struct {
+	settings *models.RedshiftDataSourceSettings
+	sts      types.STSClient
+	oidc     types.OIDCClient
+
+	mu          sync.Mutex
+	credentials map[string]aws.Credentials
+}
+
+func newIdentityCredentials(settings *models.RedshiftDataSourceSettings, sts types.STSClient, oidc types.OIDCClient) *identityCredentials {
+	return &identityCredentials{settings: settings, sts: sts, oidc: oidc, credentials: map[string]aws.Credentials{}}
+}
+
+// Retrieve returns the credentials of the user, which are cached until they are about to expire
+func (p *identityCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	token := identityTokenFromContext(ctx)
+	if token == "" {
+		return aws.Credentials{}, backend.DownstreamError(ErrMissingIdentityToken)
+	}
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	p.mu.Lock()
+	creds, ok := p.credentials[key]
+	p.mu.Unlock()
+	if ok && !creds.Expires.Add(-identityCredentialsExpiryWindow).Before(time.Now()) {
+		return creds, nil
+	}
+
+	creds, err := p.assumeRole(ctx, token)
+	if err != nil {
+		return aws.Credentials{}, backend.DownstreamError(fmt.Errorf("failed to get the AWS credentials of the user: %w", err))
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for k, c := range p.credentials {
+		if c.Expires.Before(now) {
+			delete(p.credentials, k)
+		}
+	}
+	if len(p.credentials) < maxIdentityCredentials {
+		p.credentials[key] = creds
+	}
+	return creds, nil
+}
+
+func (p *identityCredentials) assumeRole(ctx context.Context, token string) (aws.Credentials, error) {
+	sessionName := roleSessionName(backend.UserFromContext(ctx))
+	var creds *ststypes.Credentials
+	switch p.settings.IdentityPropagation {
+	case models.IdentityPropagationWebIdentity:
+		out, err := p.sts.AssumeRoleWithWebIdentity(ctx, &sts.AssumeRoleWithWebIdentityInput{
+			RoleArn:          aws.String(p.settings.IdentityRoleARN),
+			RoleSessionName:  aws.String(sessionName),
+			WebIdentityToken: aws.String(token),
+		})
+		if err != nil {
+			return aws.Credentials{}, err
+		}
+		creds = out.Credentials
+	case models.IdentityPropagationIdentityCenter:
+		out, err := p.oidc.CreateTokenWithIAM(ctx, &ssooidc.CreateTokenWithIAMInput{
+			ClientId:  aws.String(p.settings.IdentityCenterApplicationARN),
+			GrantType: aws.String(jwtBearerGrant),
+			Assertion: aws.String(token),
+		})
+		if err != nil {
+			return aws.Credentials{}, err
+		}
+		identityContext, err := jwtClaim(aws.ToString(out.IdToken), identityContextClaim)
+		if err != nil {
+			return aws.Credentials{}, err
+		}
+		assumed, err := p.sts.AssumeRole(ctx, &sts.AssumeRoleInput{
+			RoleArn:         aws.String(p.settings.IdentityRoleARN),
+			RoleSessionName: aws.String(sessionName),
+			ProvidedContexts: []ststypes.ProvidedContext{{
+				ProviderArn:      aws.String(identityCenterContextProvider),
+				ContextAssertion: aws.String(identityContext),
+			}},
+		})
+		if err != nil {
+			return aws.Credentials{}, err
+		}
+		creds = assumed.Credentials
+	default:
+		return aws.Credentials{}, fmt.Errorf("identity propagation is not enabled")
+	}
+	if creds == nil {
+		return aws.Credentials{}, fmt.Errorf("no credentials returned for role %s", p.settings.IdentityRoleARN)
+	}
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		Source:          "GrafanaIdentityPropagation",
+		CanExpire:       true,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
+
+var roleSessionNameRegexp = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// roleSessionName names the role session after the Grafana user, so that it shows in CloudTrail
+func roleSessionName(user *backend.User) string {
+	name := "grafana"
+	if user != nil && user.Login != "" {
+		name += "-" + roleSessionNameRegexp.ReplaceAllString(user.Login, "_")
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// jwtClaim returns a string claim of a JWT, without verifying it since it comes straight from AWS
+func jwtClaim(token, claim string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid token returned by IAM Identity Center")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid token returned by IAM Identity Center: %w", err)
+	}
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("invalid token returned by IAM Identity Center: %w", err)
+	}
+	value, ok := claims[claim].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("the token returned by IAM Identity Center has no %s claim", claim)
+	}
+	return value, nil
+}
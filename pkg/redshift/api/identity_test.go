@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+type fakeIdentityClient struct {
+	assumeRole            []*sts.AssumeRoleInput
+	assumeRoleWebIdentity []*sts.AssumeRoleWithWebIdentityInput
+	createToken           []*ssooidc.CreateTokenWithIAMInput
+}
+
+func (c *fakeIdentityClient) credentials() *ststypes.Credentials {
+	return &ststypes.Credentials{
+		AccessKeyId:     aws.String("key"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("session"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}
+}
+
+func (c *fakeIdentityClient) AssumeRole(_ context.Context, input *sts.AssumeRoleInput, _ ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	c.assumeRole = append(c.assumeRole, input)
+	return &sts.AssumeRoleOutput{Credentials: c.credentials()}, nil
+}
+
+func (c *fakeIdentityClient) AssumeRoleWithWebIdentity(_ context.Context, input *sts.AssumeRoleWithWebIdentityInput, _ ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	c.assumeRoleWebIdentity = append(c.assumeRoleWebIdentity, input)
+	return &sts.AssumeRoleWithWebIdentityOutput{Credentials: c.credentials()}, nil
+}
+
+func (c *fakeIdentityClient) CreateTokenWithIAM(_ context.Context, input *ssooidc.CreateTokenWithIAMInput, _ ...func(*ssooidc.Options)) (*ssooidc.CreateTokenWithIAMOutput, error) {
+	c.createToken = append(c.createToken, input)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sts:identity_context":"context"}`))
+	return &ssooidc.CreateTokenWithIAMOutput{IdToken: aws.String("header." + payload + ".signature")}, nil
+}
+
+func Test_identityCredentials_webIdentity(t *testing.T) {
+	client := &fakeIdentityClient{}
+	settings := &models.RedshiftDataSourceSettings{IdentityPropagation: models.IdentityPropagationWebIdentity, IdentityRoleARN: "arn:role"}
+	p := newIdentityCredentials(settings, client, client)
+
+	_, err := p.Retrieve(context.Background())
+	assert.ErrorIs(t, err, ErrMissingIdentityToken)
+
+	ctx := backend.WithUser(WithIdentityToken(context.Background(), "token"), &backend.User{Login: "jane doe"})
+	for i := 0; i < 2; i++ {
+		creds, err := p.Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "key", creds.AccessKeyID)
+	}
+	// the credentials are cached
+	require.Len(t, client.assumeRoleWebIdentity, 1)
+	assert.Equal(t, "token", aws.ToString(client.assumeRoleWebIdentity[0].WebIdentityToken))
+	assert.Equal(t, "grafana-jane_doe", aws.ToString(client.assumeRoleWebIdentity[0].RoleSessionName))
+}
+
+func Test_identityCredentials_identityCenter(t *testing.T) {
+	client := &fakeIdentityClient{}
+	settings := &models.RedshiftDataSourceSettings{
+		IdentityPropagation:          models.IdentityPropagationIdentityCenter,
+		IdentityRoleARN:              "arn:role",
+		IdentityCenterApplicationARN: "arn:application",
+	}
+	p := newIdentityCredentials(settings, client, client)
+	_, err := p.Retrieve(WithIdentityToken(context.Background(), "token"))
+	require.NoError(t, err)
+
+	require.Len(t, client.createToken, 1)
+	assert.Equal(t, "arn:application", aws.ToString(client.createToken[0].ClientId))
+	assert.Equal(t, "token", aws.ToString(client.createToken[0].Assertion))
+	require.Len(t, client.assumeRole, 1)
+	require.Len(t, client.assumeRole[0].ProvidedContexts, 1)
+	assert.Equal(t, "context", aws.ToString(client.assumeRole[0].ProvidedContexts[0].ContextAssertion))
+}
+
+func Test_IdentityToken(t *testing.T) {
+	assert.Equal(t, "id", IdentityToken("id", "Bearer access"))
+	assert.Equal(t, "access", IdentityToken("", "Bearer access"))
+	assert.Equal(t, "", IdentityToken("", ""))
+}
@@ -25,6 +25,12 @@ func (msm *MockRedshiftSecretsManager) GetSecretValue(_ context.Context, _ *secr
 		SecretString: aws.String(msm.Secret),
 	}, nil
 }
+func (msm *MockRedshiftSecretsManager) DescribeSecret(_ context.Context, input *secretsmanager.DescribeSecretInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+	return &secretsmanager.DescribeSecretOutput{
+		ARN:  aws.String(fmt.Sprintf("arn:%s", *input.SecretId)),
+		Name: input.SecretId,
+	}, nil
+}
 func (msm *MockRedshiftSecretsManager) ListSecrets(_ context.Context, _ *secretsmanager.ListSecretsInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.ListSecretsOutput, error) {
 	r := &secretsmanager.ListSecretsOutput{}
 	for _, c := range msm.Secrets {
@@ -57,6 +63,10 @@ func (mc *MockRedshiftClient) ExecuteStatement(_ context.Context, _ *redshiftdat
 	return mc.ExecutionResult, nil
 }
 
+func (mc *MockRedshiftClient) BatchExecuteStatement(_ context.Context, _ *redshiftdata.BatchExecuteStatementInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.BatchExecuteStatementOutput, error) {
+	return &redshiftdata.BatchExecuteStatementOutput{Id: mc.ExecutionResult.Id}, nil
+}
+
 func (mc *MockRedshiftClient) DescribeStatement(_ context.Context, _ *redshiftdata.DescribeStatementInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.DescribeStatementOutput, error) {
 	return mc.DescribeStatementOutput, nil
 }
@@ -108,9 +118,19 @@ func (mc *MockRedshiftClient) DescribeClusters(_ context.Context, _ *redshift.De
 	return &res, nil
 }
 
+func (mc *MockRedshiftClient) ResumeCluster(_ context.Context, _ *redshift.ResumeClusterInput, _ ...func(*redshift.Options)) (*redshift.ResumeClusterOutput, error) {
+	return &redshift.ResumeClusterOutput{}, nil
+}
+
 func (m *MockRedshiftClientError) DescribeClusters(_ context.Context, _ *redshift.DescribeClustersInput, _ ...func(*redshift.Options)) (*redshift.DescribeClustersOutput, error) {
 	return nil, fmt.Errorf("Boom")
 }
+func (m *MockRedshiftClientError) ResumeCluster(_ context.Context, _ *redshift.ResumeClusterInput, _ ...func(*redshift.Options)) (*redshift.ResumeClusterOutput, error) {
+	return nil, fmt.Errorf("Boom")
+}
 func (m *MockRedshiftClientNil) DescribeClusters(_ context.Context, _ *redshift.DescribeClustersInput, _ ...func(*redshift.Options)) (*redshift.DescribeClustersOutput, error) {
 	return nil, nil
 }
+func (m *MockRedshiftClientNil) ResumeCluster(_ context.Context, _ *redshift.ResumeClusterInput, _ ...func(*redshift.Options)) (*redshift.ResumeClusterOutput, error) {
+	return nil, nil
+}
@@ -44,3 +44,22 @@ func (m *MockRedshiftServerlessClientError) ListWorkgroups(_ context.Context, _
 func (m *MockRedshiftServerlessClientNil) ListWorkgroups(_ context.Context, _ *redshiftserverless.ListWorkgroupsInput, _ ...func(*redshiftserverless.Options)) (*redshiftserverless.ListWorkgroupsOutput, error) {
 	return nil, nil
 }
+
+func (m *MockRedshiftServerlessClient) GetWorkgroup(_ context.Context, input *redshiftserverless.GetWorkgroupInput, _ ...func(*redshiftserverless.Options)) (*redshiftserverless.GetWorkgroupOutput, error) {
+	for _, w := range m.Workgroups {
+		if w == *input.WorkgroupName {
+			return &redshiftserverless.GetWorkgroupOutput{Workgroup: &redshiftserverlesstypes.Workgroup{
+				WorkgroupName: aws.String(w),
+				Status:        redshiftserverlesstypes.WorkgroupStatusAvailable,
+			}}, nil
+		}
+	}
+	return nil, fmt.Errorf("workgroup %s not found", *input.WorkgroupName)
+}
+
+func (m *MockRedshiftServerlessClientError) GetWorkgroup(_ context.Context, _ *redshiftserverless.GetWorkgroupInput, _ ...func(*redshiftserverless.Options)) (*redshiftserverless.GetWorkgroupOutput, error) {
+	return nil, fmt.Errorf("Boom")
+}
+func (m *MockRedshiftServerlessClientNil) GetWorkgroup(_ context.Context, _ *redshiftserverless.GetWorkgroupInput, _ ...func(*redshiftserverless.Options)) (*redshiftserverless.GetWorkgroupOutput, error) {
+	return nil, nil
+}
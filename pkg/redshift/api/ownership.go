@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// errNotOwner is returned for queries that were not run with the identity of the user, or that the
+// datasource does not remember running, e.g. those started before the datasource settings changed
+var errNotOwner = errors.New("the query was not run by this user or is no longer known, run it again")
+
+// owner returns the identity queries of the input run with. It is empty if the datasource runs the
+// queries of every user with the same identity, in which case every user can read every query.
+func (c *API) owner(input apiInput) string {
+	if len(c.settings.IdentityMappings) == 0 && c.settings.IdentityPropagation == "" {
+		return ""
+	}
+	return aws.ToString(input.DbUser) + "\n" + aws.ToString(input.SecretARN) + "\n" + input.User
+}
+
+// ownedBy remembers the identity a query runs with, by ticket or statement ID
+func (c *API) ownedBy(id string, input apiInput) {
+	if owner := c.owner(input); owner != "" {
+		c.owners.add(id, owner)
+	}
+}
+
+// CheckOwner returns an error if the query was not run with the identity of the user of the request, so
+// that users whose identities are mapped or propagated cannot read or cancel the queries of others.
+// Queries the datasource does not remember running are rejected as well.
+func (c *API) CheckOwner(ctx context.Context, queryID string) error {
+	input, err := c.userInput(ctx)
+	if err != nil {
+		return err
+	}
+	owner := c.owner(input)
+	if owner == "" {
+		return nil
+	}
+	if ran, ok := c.owners.lookup(queryID); !ok || ran != owner {
+		return backend.DownstreamError(errNotOwner)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshift"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftdata"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftserverless"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/smithy-go"
+	"github.com/grafana/grafana-aws-sdk/pkg/sql/api"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+// permissionProbe calls an action and returns the error, if any, with which its permission is assessed.
+// A non-empty skip reason marks the action as untestable without calling it.
+type permissionProbe struct {
+	action string
+	skip   string
+	call   func() error
+}
+
+// deniedActionPattern matches the action an access denied error is about. Some actions call others with the
+// credentials of the caller, e.g. ExecuteStatement calls redshift:GetClusterCredentials, whose denial is
+// reported by the calling action.
+var deniedActionPattern = regexp.MustCompile(`not authorized to perform: ([\w-]+:\w+)`)
+
+// Permissions probes each API action the datasource uses with a harmless call: retrieving its credentials,
+// listing a single page, describing and reading the managed secret, and running and canceling "SELECT 1".
+// ResumeCluster is never called, it could resume the cluster. Calls that fail with an error of the service
+// other than access denied got past authorization, so the action is allowed. Calls that fail otherwise, or
+// depend on a call that failed, are untestable. When the error names another action than the one called,
+// that action is denied.
+func (c *API) Permissions(ctx context.Context) (*models.PermissionReport, error) {
+	input, err := c.userInput(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &models.PermissionReport{Actions: []models.ActionPermission{}, Missing: []string{}}
+	probe := func(p permissionProbe) bool {
+		res := models.ActionPermission{Action: p.action}
+		if p.skip != "" {
+			res.Status, res.Message = models.PermissionUntestable, p.skip
+			report.Actions = append(report.Actions, res)
+			return false
+		}
+		err := p.call()
+		res.Status = permissionStatus(err)
+		if err != nil {
+			res.Message = err.Error()
+		}
+		if res.Status == models.PermissionDenied {
+			denied := deniedAction(err, p.action)
+			if denied != p.action {
+				// the action was authorized, another one it calls was not
+				res.Status = models.PermissionAllowed
+				report.Actions = append(report.Actions, res)
+				res = models.ActionPermission{Action: denied, Status: models.PermissionDenied, Message: err.Error()}
+			}
+			if !slices.Contains(report.Missing, denied) {
+				report.Missing = append(report.Missing, denied)
+			}
+		}
+		report.Actions = append(report.Actions, res)
+		return err == nil
+	}
+
+	noRole := ""
+	roleAction := "sts:AssumeRole"
+	switch {
+	case c.settings.IdentityPropagation == models.IdentityPropagationWebIdentity:
+		roleAction = "sts:AssumeRoleWithWebIdentity"
+	case c.settings.IdentityPropagation == "" && c.settings.AssumeRoleARN == "":
+		noRole = "the datasource does not assume a role"
+	case c.credentials == nil:
+		noRole = "no credentials provider"
+	}
+	probe(permissionProbe{action: roleAction, skip: noRole, call: func() error {
+		_, err := c.credentials.Retrieve(ctx)
+		return err
+	}})
+
+	// the test statement is run first, the actions reading it depend on it
+	var statementID string
+	executed := probe(permissionProbe{action: "redshift-data:ExecuteStatement", call: func() error {
+		out, err := c.DataClient.ExecuteStatement(ctx, &redshiftdata.ExecuteStatementInput{
+			ClusterIdentifier: input.ClusterIdentifier,
+			Database:          input.Database,
+			DbUser:            input.DbUser,
+			SecretArn:         input.SecretARN,
+			Sql:               aws.String(healthCheckQuery),
+			StatementName:     aws.String(StatementNamePrefix(c.settings.Config.UID)),
+			WorkgroupName:     input.WorkgroupName,
+		})
+		if err == nil {
+			statementID = aws.ToString(out.Id)
+			// the test statement is read like the queries of the user, who must own it
+			c.ownedBy(statementID, input)
+		}
+		return err
+	}})
+	probe(permissionProbe{action: "redshift-data:BatchExecuteStatement", call: func() error {
+		_, err := c.DataClient.BatchExecuteStatement(ctx, &redshiftdata.BatchExecuteStatementInput{
+			ClusterIdentifier: input.ClusterIdentifier,
+			Database:          input.Database,
+			DbUser:            input.DbUser,
+			SecretArn:         input.SecretARN,
+			Sqls:              []string{healthCheckQuery},
+			StatementName:     aws.String(StatementNamePrefix(c.settings.Config.UID)),
+			WorkgroupName:     input.WorkgroupName,
+		})
+		return err
+	}})
+	noStatement := ""
+	if !executed {
+		noStatement = "requires the test statement, which could not be run"
+	}
+	described := probe(permissionProbe{action: "redshift-data:DescribeStatement", skip: noStatement, call: func() error {
+		_, err := c.DataClient.DescribeStatement(ctx, &redshiftdata.DescribeStatementInput{Id: aws.String(statementID)})
+		return err
+	}})
+	noResult := noStatement
+	if noResult == "" && !described {
+		noResult = "requires the status of the test statement"
+	}
+	if noResult == "" {
+		if err := api.WaitOnQuery(ctx, c, &api.ExecuteQueryOutput{ID: statementID}); err != nil {
+			noResult = "the test statement failed: " + err.Error()
+		}
+	}
+	probe(permissionProbe{action: "redshift-data:GetStatementResult", skip: noResult, call: func() error {
+		_, err := c.DataClient.GetStatementResult(ctx, &redshiftdata.GetStatementResultInput{Id: aws.String(statementID)})
+		return err
+	}})
+	// canceling a finished statement fails past authorization
+	probe(permissionProbe{action: "redshift-data:CancelStatement", skip: noStatement, call: func() error {
+		_, err := c.DataClient.CancelStatement(ctx, &redshiftdata.CancelStatementInput{Id: aws.String(statementID)})
+		return err
+	}})
+	probe(permissionProbe{action: "redshift-data:ListStatements", call: func() error {
+		_, err := c.DataClient.ListStatements(ctx, &redshiftdata.ListStatementsInput{
+			StatementName: aws.String(StatementNamePrefix(c.settings.Config.UID)),
+			MaxResults:    aws.Int32(1),
+		})
+		return err
+	}})
+
+	probe(permissionProbe{action: "redshift-data:ListDatabases", call: func() error {
+		_, err := c.DataClient.ListDatabases(ctx, &redshiftdata.ListDatabasesInput{
+			ClusterIdentifier: input.ClusterIdentifier,
+			Database:          input.Database,
+			DbUser:            input.DbUser,
+			SecretArn:         input.SecretARN,
+			WorkgroupName:     input.WorkgroupName,
+			MaxResults:        aws.Int32(1),
+		})
+		return err
+	}})
+	probe(permissionProbe{action: "redshift-data:ListSchemas", call: func() error {
+		_, err := c.DataClient.ListSchemas(ctx, &redshiftdata.ListSchemasInput{
+			ClusterIdentifier: input.ClusterIdentifier,
+			Database:          input.Database,
+			DbUser:            input.DbUser,
+			SecretArn:         input.SecretARN,
+			WorkgroupName:     input.WorkgroupName,
+			MaxResults:        aws.Int32(1),
+		})
+		return err
+	}})
+	var table *string
+	probe(permissionProbe{action: "redshift-data:ListTables", call: func() error {
+		out, err := c.DataClient.ListTables(ctx, &redshiftdata.ListTablesInput{
+			ClusterIdentifier: input.ClusterIdentifier,
+			Database:          input.Database,
+			DbUser:            input.DbUser,
+			SecretArn:         input.SecretARN,
+			SchemaPattern:     aws.String("public"),
+			WorkgroupName:     input.WorkgroupName,
+			MaxResults:        aws.Int32(1),
+		})
+		if err == nil && len(out.Tables) > 0 {
+			table = out.Tables[0].Name
+		}
+		return err
+	}})
+	noTable := ""
+	if table == nil {
+		noTable = "requires a table in the public schema"
+	}
+	probe(permissionProbe{action: "redshift-data:DescribeTable", skip: noTable, call: func() error {
+		_, err := c.DataClient.DescribeTable(ctx, &redshiftdata.DescribeTableInput{
+			ClusterIdentifier: input.ClusterIdentifier,
+			Database:          input.Database,
+			DbUser:            input.DbUser,
+			SecretArn:         input.SecretARN,
+			Schema:            aws.String("public"),
+			Table:             table,
+			WorkgroupName:     input.WorkgroupName,
+			MaxResults:        aws.Int32(1),
+		})
+		return err
+	}})
+
+	probe(permissionProbe{action: "secretsmanager:ListSecrets", call: func() error {
+		_, err := c.SecretsClient.ListSecrets(ctx, &secretsmanager.ListSecretsInput{MaxResults: aws.Int32(1)})
+		return err
+	}})
+	noSecret := ""
+	if input.SecretARN == nil {
+		noSecret = "the datasource does not use a managed secret"
+	}
+	probe(permissionProbe{action: "secretsmanager:DescribeSecret", skip: noSecret, call: func() error {
+		_, err := c.SecretsClient.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: input.SecretARN})
+		return err
+	}})
+	probe(permissionProbe{action: "secretsmanager:GetSecretValue", skip: noSecret, call: func() error {
+		_, err := c.SecretsClient.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: input.SecretARN})
+		return err
+	}})
+
+	probe(permissionProbe{action: "redshift:DescribeClusters", call: func() error {
+		_, err := c.ManagementClient.DescribeClusters(ctx, &redshift.DescribeClustersInput{MaxRecords: aws.Int32(20)})
+		return err
+	}})
+	// no call resumes a cluster without changing it, clusters are only resumed by queries
+	noResume := "it is not called, it could resume the cluster"
+	if input.ClusterIdentifier == nil || !c.settings.ResumePausedClusters {
+		noResume = "the datasource does not resume paused clusters"
+	}
+	probe(permissionProbe{action: "redshift:ResumeCluster", skip: noResume})
+	probe(permissionProbe{action: "redshift-serverless:ListWorkgroups", call: func() error {
+		_, err := c.ServerlessManagementClient.ListWorkgroups(ctx, &redshiftserverless.ListWorkgroupsInput{MaxResults: aws.Int32(1)})
+		return err
+	}})
+	noWorkgroup := ""
+	if input.WorkgroupName == nil {
+		noWorkgroup = "the datasource does not use a workgroup"
+	}
+	probe(permissionProbe{action: "redshift-serverless:GetWorkgroup", skip: noWorkgroup, call: func() error {
+		_, err := c.ServerlessManagementClient.GetWorkgroup(ctx, &redshiftserverless.GetWorkgroupInput{WorkgroupName: input.WorkgroupName})
+		return err
+	}})
+	return report, nil
+}
+
+// deniedAction returns the action an access denied error names, or the action called if it names none
+func deniedAction(err error, called string) string {
+	if m := deniedActionPattern.FindStringSubmatch(err.Error()); m != nil {
+		return m[1]
+	}
+	return called
+}
+
+// permissionStatus assesses the permission of an action from the error of a call to it
+func permissionStatus(err error) string {
+	if err == nil {
+		return models.PermissionAllowed
+	}
+	if isAccessDenied(err) {
+		return models.PermissionDenied
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return models.PermissionAllowed
+	}
+	return models.PermissionUntestable
+}
+
+func isAccessDenied(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "AccessDenied", "AccessDeniedException", "UnauthorizedOperation", "UnauthorizedException":
+			return true
+		}
+	}
+	return strings.Contains(err.Error(), "not authorized to perform")
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftdata"
+	redshiftdatatypes "github.com/aws/aws-sdk-go-v2/service/redshiftdata/types"
+	"github.com/aws/smithy-go"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/api/mock"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+// restrictedClient is a Data API client whose role cannot list schemas and whose database cannot be listed
+type restrictedClient struct {
+	mock.MockRedshiftClient
+}
+
+func (c *restrictedClient) ListSchemas(_ context.Context, _ *redshiftdata.ListSchemasInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.ListSchemasOutput, error) {
+	return nil, &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "User is not authorized to perform: redshift-data:ListSchemas"}
+}
+
+func (c *restrictedClient) ListDatabases(_ context.Context, _ *redshiftdata.ListDatabasesInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.ListDatabasesOutput, error) {
+	return nil, &smithy.GenericAPIError{Code: "ValidationException", Message: "permission denied for database dev"}
+}
+
+func (c *restrictedClient) GetStatementResult(_ context.Context, _ *redshiftdata.GetStatementResultInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.GetStatementResultOutput, error) {
+	return nil, errors.New("connection reset by peer")
+}
+
+func (c *restrictedClient) CancelStatement(_ context.Context, _ *redshiftdata.CancelStatementInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.CancelStatementOutput, error) {
+	return nil, &smithy.GenericAPIError{Code: "ValidationException", Message: "Could not cancel a query that is already in FINISHED state"}
+}
+
+// noCredentialsClient is a Data API client whose role can run statements but not get cluster credentials
+type noCredentialsClient struct {
+	restrictedClient
+}
+
+func (c *noCredentialsClient) ExecuteStatement(_ context.Context, _ *redshiftdata.ExecuteStatementInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.ExecuteStatementOutput, error) {
+	return nil, &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "User: arn:aws:iam::123456789012:role/grafana is not authorized to perform: redshift:GetClusterCredentials on resource: arn:aws:redshift:us-east-1:123456789012:dbuser:nightly/grafana"}
+}
+
+func (c *noCredentialsClient) BatchExecuteStatement(ctx context.Context, _ *redshiftdata.BatchExecuteStatementInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.BatchExecuteStatementOutput, error) {
+	_, err := c.ExecuteStatement(ctx, nil)
+	return nil, err
+}
+
+func Test_Permissions(t *testing.T) {
+	c := &API{
+		settings: &models.RedshiftDataSourceSettings{ClusterIdentifier: "nightly", DBUser: "grafana", Database: "dev"},
+		DataClient: &restrictedClient{mock.MockRedshiftClient{
+			ExecutionResult:         &redshiftdata.ExecuteStatementOutput{Id: aws.String("statement")},
+			DescribeStatementOutput: &redshiftdata.DescribeStatementOutput{Status: redshiftdatatypes.StatusStringFinished},
+			Resources:               map[string]map[string][]string{"public": {}},
+		}},
+		SecretsClient:              &mock.MockRedshiftSecretsManager{},
+		ManagementClient:           &mock.MockRedshiftClient{},
+		ServerlessManagementClient: &mock.MockRedshiftServerlessClient{},
+		scheduler:                  newScheduler(1),
+	}
+	report, err := c.Permissions(context.Background())
+	require.NoError(t, err)
+
+	statuses := map[string]string{}
+	for _, action := range report.Actions {
+		statuses[action.Action] = action.Status
+	}
+	assert.Equal(t, map[string]string{
+		"sts:AssumeRole":                      models.PermissionUntestable,
+		"redshift-data:ExecuteStatement":      models.PermissionAllowed,
+		"redshift-data:BatchExecuteStatement": models.PermissionAllowed,
+		"redshift-data:DescribeStatement":     models.PermissionAllowed,
+		"redshift-data:GetStatementResult":    models.PermissionUntestable,
+		"redshift-data:CancelStatement":       models.PermissionAllowed,
+		"redshift-data:ListStatements":        models.PermissionAllowed,
+		"redshift-data:ListDatabases":         models.PermissionAllowed,
+		"redshift-data:ListSchemas":           models.PermissionDenied,
+		"redshift-data:ListTables":            models.PermissionAllowed,
+		"redshift-data:DescribeTable":         models.PermissionUntestable,
+		"secretsmanager:ListSecrets":          models.PermissionAllowed,
+		"secretsmanager:DescribeSecret":       models.PermissionUntestable,
+		"secretsmanager:GetSecretValue":       models.PermissionUntestable,
+		"redshift:DescribeClusters":           models.PermissionAllowed,
+		"redshift:ResumeCluster":              models.PermissionUntestable,
+		"redshift-serverless:ListWorkgroups":  models.PermissionAllowed,
+		"redshift-serverless:GetWorkgroup":    models.PermissionUntestable,
+	}, statuses)
+	assert.Equal(t, []string{"redshift-data:ListSchemas"}, report.Missing)
+}
+
+func Test_Permissions_deniedByCalledAction(t *testing.T) {
+	c := &API{
+		settings:                   &models.RedshiftDataSourceSettings{ClusterIdentifier: "nightly", DBUser: "grafana", Database: "dev"},
+		DataClient:                 &noCredentialsClient{},
+		SecretsClient:              &mock.MockRedshiftSecretsManager{},
+		ManagementClient:           &mock.MockRedshiftClient{},
+		ServerlessManagementClient: &mock.MockRedshiftServerlessClient{},
+		scheduler:                  newScheduler(1),
+	}
+	report, err := c.Permissions(context.Background())
+	require.NoError(t, err)
+
+	statuses := map[string]string{}
+	for _, action := range report.Actions {
+		statuses[action.Action] = action.Status
+	}
+	// the statements are authorized, the credentials they are run with are not
+	assert.Equal(t, models.PermissionAllowed, statuses["redshift-data:ExecuteStatement"])
+	assert.Equal(t, models.PermissionAllowed, statuses["redshift-data:BatchExecuteStatement"])
+	assert.Equal(t, models.PermissionDenied, statuses["redshift:GetClusterCredentials"])
+	assert.Equal(t, []string{"redshift:GetClusterCredentials", "redshift-data:ListSchemas"}, report.Missing)
+}
+
+func Test_Permissions_mappedIdentity(t *testing.T) {
+	management := &fakeManagementClient{status: clusterAvailable}
+	c := &API{
+		settings: &models.RedshiftDataSourceSettings{
+			ClusterIdentifier:    "nightly",
+			DBUser:               "grafana",
+			Database:             "dev",
+			IdentityMappings:     []models.IdentityMapping{{Users: []string{"alice"}, DBUser: "analyst"}},
+			ResumePausedClusters: true,
+		},
+		DataClient: &restrictedClient{mock.MockRedshiftClient{
+			ExecutionResult:         &redshiftdata.ExecuteStatementOutput{Id: aws.String("statement")},
+			DescribeStatementOutput: &redshiftdata.DescribeStatementOutput{Status: redshiftdatatypes.StatusStringFinished},
+		}},
+		SecretsClient:              &mock.MockRedshiftSecretsManager{},
+		ManagementClient:           management,
+		ServerlessManagementClient: &mock.MockRedshiftServerlessClient{},
+		scheduler:                  newScheduler(1),
+		owners:                     newStatementLog(),
+	}
+	report, err := c.Permissions(backend.WithUser(context.Background(), &backend.User{Login: "alice"}))
+	require.NoError(t, err)
+
+	messages := map[string]string{}
+	for _, action := range report.Actions {
+		messages[action.Action] = action.Message
+	}
+	// the test statement is owned by the user, so its result is read
+	assert.Equal(t, "connection reset by peer", messages["redshift-data:GetStatementResult"])
+	// the cluster is never resumed to test the permission
+	assert.Equal(t, "it is not called, it could resume the cluster", messages["redshift:ResumeCluster"])
+	assert.Equal(t, 0, management.resumed)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+const (
+	defaultResultCacheTTL = 5 * time.Minute
+	// the Data API keeps the results of a statement for 24 hours
+	maxResultCacheTTL = 24 * time.Hour
+)
+
+// configuredResultCache returns a cache configured with the settings, or nil if the cache is disabled
+func configuredResultCache(settings *models.RedshiftDataSourceSettings) *resultCache {
+	if !settings.UseResultCache {
+		return nil
+	}
+	ttl := defaultResultCacheTTL
+	if settings.ResultCacheTTLSeconds > 0 {
+		ttl = min(time.Duration(settings.ResultCacheTTLSeconds)*time.Second, maxResultCacheTTL)
+	}
+	return newResultCache(ttl)
+}
+
+type cachedResult struct {
+	id      string
+	expires time.Time
+}
+
+// resultCache maps queries to statements that ran them successfully, so that the
+// result of those statements can be read again instead of re-running the query
+type resultCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	results map[string]cachedResult
+}
+
+func newResultCache(ttl time.Duration) *resultCache {
+	return &resultCache{ttl: ttl, results: map[string]cachedResult{}}
+}
+
+// get returns the ID of a statement that ran the query, if it finished within the TTL
+func (c *resultCache) get(key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, ok := c.results[key]
+	if !ok || time.Now().After(res.expires) {
+		return "", false
+	}
+	return res.id, true
+}
+
+// add records a statement that just finished running the query
+func (c *resultCache) add(key string, id string) {
+	if c == nil || key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, res := range c.results {
+		if now.After(res.expires) {
+			delete(c.results, k)
+		}
+	}
+	c.results[key] = cachedResult{id: id, expires: now.Add(c.ttl)}
+}
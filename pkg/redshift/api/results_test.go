@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	redshiftdatatypes "github.com/aws/aws-sdk-go-v2/service/redshiftdata/types"
+	sqlAPI "github.com/grafana/grafana-aws-sdk/pkg/sql/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+func Test_resultCache(t *testing.T) {
+	client := &statementsClient{statuses: map[string]redshiftdatatypes.StatusString{}}
+	c := &API{
+		settings:   &models.RedshiftDataSourceSettings{},
+		DataClient: client,
+		scheduler:  newScheduler(1),
+		results:    newResultCache(time.Minute),
+	}
+	first, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 1"})
+	require.NoError(t, err)
+	client.finish(first.ID)
+	require.Eventually(t, func() bool {
+		_, ok := c.results.get(c.queryKey(c.apiInput(), "select 1"))
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	second, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select\n  1"})
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, 1, client.executed)
+
+	_, err = c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 2"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, client.executed)
+
+	// results of a previous version of the settings are not reused
+	c.settings.Config.Updated = time.Now()
+	third, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 1"})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, third.ID)
+}
+
+func Test_resultCache_expiry(t *testing.T) {
+	cache := newResultCache(time.Minute)
+	cache.add("key", "id")
+	id, ok := cache.get("key")
+	assert.True(t, ok)
+	assert.Equal(t, "id", id)
+
+	cache.results["key"] = cachedResult{id: "id", expires: time.Now()}
+	_, ok = cache.get("key")
+	assert.False(t, ok)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshift"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	clusterAvailable = "available"
+	clusterPaused    = "paused"
+	clusterResuming  = "resuming"
+
+	defaultResumeTimeout = 15 * time.Minute
+)
+
+// resumePollInterval is how often the status of a resuming cluster is checked
+var resumePollInterval = 30 * time.Second
+
+// resumes makes the queries of a datasource that find the same cluster paused share a single resume
+var resumes singleflight.Group
+
+// clusterStatus returns the status of a provisioned cluster, e.g. "available" or "paused"
+func (c *API) clusterStatus(ctx context.Context, clusterID string) (string, error) {
+	out, err := c.ManagementClient.DescribeClusters(ctx, &redshift.DescribeClustersInput{ClusterIdentifier: aws.String(clusterID)})
+	if err != nil {
+		return "", err
+	}
+	if out == nil || len(out.Clusters) == 0 {
+		return "", fmt.Errorf("cluster %s not found", clusterID)
+	}
+	return aws.ToString(out.Clusters[0].ClusterStatus), nil
+}
+
+// resumingError is returned for statements that can only be submitted once their cluster has resumed
+type resumingError struct {
+	clusterID string
+	done      <-chan singleflight.Result
+}
+
+func (e *resumingError) Error() string {
+	return fmt.Sprintf("the cluster %s is paused, it is being resumed", e.clusterID)
+}
+
+// checkPaused is called when a statement could not be submitted to a cluster. If the error says the cluster
+// is unavailable and it is paused or resuming, it returns an error saying so instead of the one of the Data
+// API. If the datasource resumes paused clusters, the cluster is resumed in the background and, if wait is
+// set, a resumingError is returned: the scheduler then keeps the statement pending, without holding a slot,
+// and submits it again once the cluster is available. Otherwise the statement fails over. Other errors,
+// e.g. SQL errors, are returned as is without describing the cluster.
+func (c *API) checkPaused(ctx context.Context, clusterID string, err error, wait bool) error {
+	if isThrottlingError(err) || !isUnavailableError(err) {
+		return err
+	}
+	status, statusErr := c.clusterStatus(ctx, clusterID)
+	if statusErr != nil {
+		backend.Logger.Debug("failed to get the status of the cluster", "cluster", clusterID, "error", statusErr)
+		return err
+	}
+	if status != clusterPaused && status != clusterResuming {
+		return err
+	}
+	if !c.settings.ResumePausedClusters {
+		if status == clusterResuming {
+			return fmt.Errorf("the cluster %s is resuming, try again in a few minutes", clusterID)
+		}
+		return fmt.Errorf("the cluster %s is paused, resume it or enable resuming paused clusters in the datasource settings", clusterID)
+	}
+	done := c.resume(ctx, clusterID)
+	if !wait {
+		return fmt.Errorf("the cluster %s is paused, it is being resumed", clusterID)
+	}
+	return &resumingError{clusterID: clusterID, done: done}
+}
+
+// submitWhenResumed submits a statement outside of the scheduler. If its cluster is resuming, it waits
+// for the cluster to be available and submits the statement again.
+func submitWhenResumed(ctx context.Context, submit func(context.Context) (string, error)) (string, error) {
+	id, err := submit(ctx)
+	var resuming *resumingError
+	if !errors.As(err, &resuming) {
+		return id, err
+	}
+	select {
+	case res := <-resuming.done:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return submit(ctx)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// resume resumes a paused cluster and waits for it to be available, up to the resume timeout of the datasource.
+// It keeps going when the query that started it is canceled, since other queries may be waiting for it.
+func (c *API) resume(ctx context.Context, clusterID string) <-chan singleflight.Result {
+	key := fmt.Sprintf("%d/%s", c.settings.Config.ID, clusterID)
+	return resumes.DoChan(key, func() (interface{}, error) {
+		timeout := c.resumeTimeout()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		status, err := c.clusterStatus(ctx, clusterID)
+		if err != nil {
+			return nil, err
+		}
+		if status == clusterPaused {
+			backend.Logger.Info("resuming paused cluster", "cluster", clusterID)
+			if _, err := c.ManagementClient.ResumeCluster(ctx, &redshift.ResumeClusterInput{ClusterIdentifier: aws.String(clusterID)}); err != nil {
+				return nil, fmt.Errorf("failed to resume the paused cluster %s: %w", clusterID, err)
+			}
+		}
+		for status != clusterAvailable {
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("the cluster %s is still resuming after %s, try again later", clusterID, timeout)
+			case <-time.After(resumePollInterval):
+			}
+			if status, err = c.clusterStatus(ctx, clusterID); err != nil {
+				return nil, err
+			}
+		}
+		backend.Logger.Info("resumed cluster", "cluster", clusterID)
+		return nil, nil
+	})
+}
+
+func (c *API) resumeTimeout() time.Duration {
+	if c.settings.ResumeTimeoutSeconds > 0 {
+		return time.Duration(c.settings.ResumeTimeoutSeconds) * time.Second
+	}
+	return defaultResumeTimeout
+}
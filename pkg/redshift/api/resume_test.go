@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshift"
+	redshifttypes "github.com/aws/aws-sdk-go-v2/service/redshift/types"
+	"github.com/grafana/grafana-aws-sdk/pkg/sql/api"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+// fakeManagementClient reports the cluster paused until it is resumed, then resuming for a few checks
+type fakeManagementClient struct {
+	mu        sync.Mutex
+	status    string
+	described int
+	err       error
+	checks    int
+	resumed   int
+	onResume  func()
+}
+
+func (c *fakeManagementClient) DescribeClusters(_ context.Context, input *redshift.DescribeClustersInput, _ ...func(*redshift.Options)) (*redshift.DescribeClustersOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.described++
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.status == clusterResuming {
+		if c.checks++; c.checks == 3 {
+			c.status = clusterAvailable
+			c.onResume()
+		}
+	}
+	return &redshift.DescribeClustersOutput{Clusters: []redshifttypes.Cluster{{
+		ClusterIdentifier: input.ClusterIdentifier,
+		ClusterStatus:     aws.String(c.status),
+	}}}, nil
+}
+
+func (c *fakeManagementClient) ResumeCluster(_ context.Context, _ *redshift.ResumeClusterInput, _ ...func(*redshift.Options)) (*redshift.ResumeClusterOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resumed++
+	c.status = clusterResuming
+	return &redshift.ResumeClusterOutput{}, nil
+}
+
+func Test_Execute_pausedCluster(t *testing.T) {
+	resumePollInterval = time.Millisecond
+	t.Cleanup(func() { resumePollInterval = 30 * time.Second })
+	newAPI := func(resume bool) (*API, *failingTargetsClient, *fakeManagementClient) {
+		client := &failingTargetsClient{errs: map[string]error{"nightly": errors.New("ValidationException: Redshift endpoint doesn't exist in this region")}}
+		management := &fakeManagementClient{status: clusterPaused, onResume: func() {
+			client.mu.Lock()
+			defer client.mu.Unlock()
+			delete(client.errs, "nightly")
+		}}
+		settings := &models.RedshiftDataSourceSettings{
+			Config:               backend.DataSourceInstanceSettings{ID: 45},
+			ClusterIdentifier:    "nightly",
+			DBUser:               "grafana",
+			ResumePausedClusters: resume,
+		}
+		return &API{settings: settings, DataClient: client, ManagementClient: management}, client, management
+	}
+
+	t.Run("returns a clear error", func(t *testing.T) {
+		c, _, management := newAPI(false)
+		_, err := c.Execute(context.Background(), &api.ExecuteQueryInput{Query: "select 1"})
+		assert.ErrorContains(t, err, "the cluster nightly is paused, resume it or enable resuming paused clusters")
+		assert.Equal(t, 0, management.resumed)
+	})
+
+	t.Run("does not describe the cluster for other errors", func(t *testing.T) {
+		c, client, management := newAPI(true)
+		client.errs["nightly"] = errors.New("ValidationException: syntax error at or near \"selec\"")
+		_, err := c.Execute(context.Background(), &api.ExecuteQueryInput{Query: "select 1"})
+		assert.ErrorContains(t, err, "syntax error")
+		assert.Equal(t, 0, management.described)
+	})
+
+	t.Run("resumes the cluster and waits for it", func(t *testing.T) {
+		c, client, management := newAPI(true)
+		res, err := c.Execute(context.Background(), &api.ExecuteQueryInput{Query: "select 1"})
+		require.NoError(t, err)
+		assert.Equal(t, "nightly-statement", res.ID)
+		assert.Equal(t, 1, management.resumed)
+		assert.Equal(t, []string{"nightly", "nightly"}, client.submitted)
+	})
+
+	t.Run("keeps the statement queued while the cluster resumes", func(t *testing.T) {
+		c, client, management := newAPI(true)
+		c.settings.Config.ID = 47
+		c.scheduler = newScheduler(1)
+		res, err := c.Execute(context.Background(), &api.ExecuteQueryInput{Query: "select 1"})
+		require.NoError(t, err)
+		assert.Contains(t, res.ID, queuedPrefix)
+		c.scheduler.mu.Lock()
+		assert.Equal(t, 0, c.scheduler.inFlight)
+		c.scheduler.mu.Unlock()
+		assert.Eventually(t, func() bool { return c.StatementID(res.ID) == "nightly-statement" }, time.Second, time.Millisecond)
+		management.mu.Lock()
+		defer management.mu.Unlock()
+		assert.Equal(t, 1, management.resumed)
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		assert.Equal(t, []string{"nightly", "nightly"}, client.submitted)
+	})
+
+	t.Run("cancels a statement waiting for its cluster to resume", func(t *testing.T) {
+		c, client, management := newAPI(true)
+		c.settings.Config.ID = 48
+		c.scheduler = newScheduler(1)
+		res, err := c.Execute(context.Background(), &api.ExecuteQueryInput{Query: "select 1"})
+		require.NoError(t, err)
+		assert.True(t, c.scheduler.dequeue(res.ID))
+		status, _, ok := c.scheduler.status(res.ID)
+		require.True(t, ok)
+		assert.True(t, status.Finished)
+		// the cluster is still resumed, but the statement is not submitted once it is available
+		assert.Eventually(t, func() bool {
+			c.scheduler.mu.Lock()
+			defer c.scheduler.mu.Unlock()
+			return !c.scheduler.statements[res.ID].resuming
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, 1, management.resumed)
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		assert.Equal(t, []string{"nightly"}, client.submitted)
+	})
+
+	t.Run("fails over while the cluster resumes", func(t *testing.T) {
+		c, client, management := newAPI(true)
+		c.settings.Config.ID = 46
+		c.settings.FailoverTargets = []models.Target{{WorkgroupName: "serverless"}}
+		res, err := c.Execute(context.Background(), &api.ExecuteQueryInput{Query: "select 1"})
+		require.NoError(t, err)
+		assert.Equal(t, "serverless-statement", res.ID)
+		assert.Equal(t, []string{"nightly", "serverless"}, client.submitted)
+		assert.Eventually(t, func() bool {
+			management.mu.Lock()
+			defer management.mu.Unlock()
+			return management.resumed == 1 && management.status == clusterAvailable
+		}, time.Second, time.Millisecond)
+	})
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+const (
+	defaultRetryMaxAttempts = 5
+	defaultRetryBaseDelay   = 200 * time.Millisecond
+	defaultRetryMaxDelay    = 10 * time.Second
+	defaultRetryBudget      = 100
+)
+
+// backoff is a jittered exponential backoff starting at baseDelay
+type backoff struct {
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// BackoffDelay returns a random duration between zero and the exponential backoff of the attempt ("full jitter")
+func (b backoff) BackoffDelay(attempt int, _ error) (time.Duration, error) {
+	delay := b.maxDelay
+	if attempt < 32 && b.baseDelay<<attempt < b.maxDelay {
+		delay = b.baseDelay << attempt
+	}
+	return rand.N(delay + 1), nil
+}
+
+// retryBudget returns the rate limiter of the retries of a datasource. The budget is a number of
+// retries, refilled as calls succeed, that every API instance of the datasource draws from.
+func retryBudget(settings *models.RedshiftDataSourceSettings) *ratelimit.TokenRateLimit {
+	budget := defaultRetryBudget
+	if settings.RetryBudget > 0 {
+		budget = settings.RetryBudget
+	}
+	return ratelimit.NewTokenRateLimit(uint(budget) * retry.DefaultRetryCost)
+}
+
+// newRetryer returns the retryer of the AWS clients, which retries throttled and transient
+// errors with the policy of the settings, drawing from the retry budget of the datasource
+func newRetryer(settings *models.RedshiftDataSourceSettings, budget retry.RateLimiter) func() aws.Retryer {
+	maxAttempts := defaultRetryMaxAttempts
+	if settings.RetryMaxAttempts > 0 {
+		maxAttempts = settings.RetryMaxAttempts
+	}
+	delay := backoff{baseDelay: defaultRetryBaseDelay, maxDelay: defaultRetryMaxDelay}
+	if settings.RetryBaseDelayMs > 0 {
+		delay.baseDelay = time.Duration(settings.RetryBaseDelayMs) * time.Millisecond
+	}
+	if settings.RetryMaxDelayMs > 0 {
+		delay.maxDelay = time.Duration(settings.RetryMaxDelayMs) * time.Millisecond
+	}
+	return func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			o.MaxAttempts = maxAttempts
+			o.MaxBackoff = delay.maxDelay
+			o.Backoff = delay
+			o.RateLimiter = budget
+		})
+	}
+}
+
+func isThrottlingError(err error) bool {
+	return retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+func Test_newRetryer(t *testing.T) {
+	settings := &models.RedshiftDataSourceSettings{RetryMaxAttempts: 3, RetryBaseDelayMs: 10, RetryMaxDelayMs: 50, RetryBudget: 1}
+	state := NewDatasourceState()
+	state.init(settings)
+	throttling := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+
+	first := newRetryer(settings, state.retryBudget)()
+	second := newRetryer(settings, state.retryBudget)()
+	assert.Equal(t, 3, first.MaxAttempts())
+	assert.True(t, first.IsErrorRetryable(throttling))
+
+	delay, err := first.RetryDelay(10, throttling)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, delay, 50*time.Millisecond)
+
+	// the budget is shared by the retryers of the datasource
+	_, err = first.GetRetryToken(context.Background(), throttling)
+	require.NoError(t, err)
+	_, err = second.GetRetryToken(context.Background(), throttling)
+	assert.Error(t, err)
+
+	// other datasources have their own budget
+	other := NewDatasourceState()
+	other.init(settings)
+	_, err = newRetryer(settings, other.retryBudget)().GetRetryToken(context.Background(), throttling)
+	assert.NoError(t, err)
+}
+
+func Test_backoff(t *testing.T) {
+	b := backoff{baseDelay: 100 * time.Millisecond, maxDelay: time.Second}
+	for attempt := 0; attempt < 40; attempt++ {
+		d, err := b.BackoffDelay(attempt, nil)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, d, time.Duration(0))
+		require.LessOrEqual(t, d, time.Second)
+	}
+}
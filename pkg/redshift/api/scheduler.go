@@ -0,0 +1,488 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	redshiftdatatypes "github.com/aws/aws-sdk-go-v2/service/redshiftdata/types"
+	"github.com/grafana/grafana-aws-sdk/pkg/sql/api"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+const (
+	defaultMaxConcurrentStatements = 50
+
+	queuedPrefix = "queued-"
+
+	minPollInterval  = 100 * time.Millisecond
+	maxPollInterval  = 5 * time.Second
+	pollIntervalRate = 1.5
+	pollTimeout      = 30 * time.Second
+	// how many times in a row the status of a statement can fail to be retrieved before
+	// the statement is failed, freeing its slot
+	defaultMaxPollErrors = 10
+	// finished statements are kept around so that callers can still read their status
+	finishedRetention = 10 * time.Minute
+)
+
+// errExpiredTicket is returned for tickets the scheduler does not know, e.g. those of statements queued
+// before the datasource settings changed
+var errExpiredTicket = errors.New("the queued query is no longer known, run it again")
+
+// maxConcurrentStatements returns the number of statements the datasource can have running at once
+func maxConcurrentStatements(settings *models.RedshiftDataSourceSettings) int {
+	if settings.MaxConcurrentStatements > 0 {
+		return settings.MaxConcurrentStatements
+	}
+	return defaultMaxConcurrentStatements
+}
+
+// scheduledStatement is a statement tracked by the scheduler. Queued statements are
+// identified by a ticket until they are submitted and get a Redshift statement ID.
+type scheduledStatement struct {
+	ticket string
+	id     string
+	key    string
+	api    *API
+	ctx    context.Context
+	submit func(context.Context) (string, error)
+
+	status     *api.ExecuteQueryStatus
+	err        error
+	interval   time.Duration
+	nextPoll   time.Time
+	finishedAt time.Time
+	// consecutive failures to retrieve the status
+	pollErrors int
+	// set while the statement waits for its cluster to resume, outside of the queue
+	resuming bool
+	// called once a queued statement is submitted
+	onSubmit []func()
+}
+
+func (st *scheduledStatement) finish(state string, err error) {
+	st.status = &api.ExecuteQueryStatus{ID: st.ticket, State: state, Finished: true}
+	st.err = err
+	st.finishedAt = time.Now()
+}
+
+// scheduler limits the number of statements a datasource has running at the same time,
+// queueing the rest, and polls the status of every running statement from a single loop
+// so that concurrent queries do not each poll DescribeStatement on their own
+type scheduler struct {
+	maxInFlight   int
+	maxPollErrors int
+
+	mu         sync.Mutex
+	inFlight   int
+	queue      []*scheduledStatement
+	statements map[string]*scheduledStatement
+	// tickets of tracked statements by query key, so that identical queries can attach to them
+	queries map[string]string
+	polling bool
+	wake    chan struct{}
+	// closed schedulers submit statements directly and stop polling
+	closed bool
+}
+
+func newScheduler(maxInFlight int) *scheduler {
+	return &scheduler{
+		maxInFlight:   maxInFlight,
+		maxPollErrors: defaultMaxPollErrors,
+		statements:    map[string]*scheduledStatement{},
+		queries:       map[string]string{},
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// execute submits the statement if there is a free slot, returning its ID. Otherwise the statement
+// is queued and a ticket is returned that can be used in place of the ID until it is submitted.
+// Statements whose cluster is resuming get a ticket as well, and are queued again once it is available.
+// The key identifies the query so that later identical queries can find the statement with lookup.
+func (s *scheduler) execute(ctx context.Context, c *API, key string, submit func(context.Context) (string, error)) (string, error) {
+	if s == nil {
+		return submitWhenResumed(ctx, submit)
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return submitWhenResumed(ctx, submit)
+	}
+	s.evict(time.Now())
+	if s.inFlight < s.maxInFlight {
+		s.inFlight++
+		s.mu.Unlock()
+		id, err := submit(ctx)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err != nil {
+			s.release()
+			var resuming *resumingError
+			if !errors.As(err, &resuming) {
+				return "", err
+			}
+			st := s.pending(ctx, c, key, submit)
+			s.waitForResume(st, resuming)
+			return st.ticket, nil
+		}
+		s.track(&scheduledStatement{ticket: id, id: id, key: key, api: c, ctx: context.WithoutCancel(ctx)})
+		return id, nil
+	}
+	st := s.pending(ctx, c, key, submit)
+	s.queue = append(s.queue, st)
+	s.start()
+	s.mu.Unlock()
+	backend.Logger.Debug("statement queued", "ticket", st.ticket, "queueDepth", len(s.queue))
+	return st.ticket, nil
+}
+
+// pending tracks a statement that is not submitted yet by a ticket. Must be called with the lock held.
+func (s *scheduler) pending(ctx context.Context, c *API, key string, submit func(context.Context) (string, error)) *scheduledStatement {
+	st := &scheduledStatement{
+		ticket: newTicket(),
+		key:    key,
+		api:    c,
+		ctx:    context.WithoutCancel(ctx),
+		submit: submit,
+		status: &api.ExecuteQueryStatus{State: string(redshiftdatatypes.StatusStringSubmitted)},
+	}
+	st.status.ID = st.ticket
+	s.statements[st.ticket] = st
+	s.queries[key] = st.ticket
+	return st
+}
+
+// waitForResume keeps a statement whose cluster is resuming out of the queue, so that it does not hold
+// a slot, and puts it back at the head of the queue once the cluster is available. Must be called with
+// the lock held.
+func (s *scheduler) waitForResume(st *scheduledStatement, resuming *resumingError) {
+	st.resuming = true
+	backend.Logger.Debug("statement waiting for its cluster to resume", "ticket", st.ticket, "cluster", resuming.clusterID)
+	go func() {
+		res := <-resuming.done
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		st.resuming = false
+		if st.status.Finished {
+			// canceled, or aborted when the scheduler was closed
+			return
+		}
+		if res.Err != nil {
+			st.finish(string(redshiftdatatypes.StatusStringFailed), res.Err)
+			st.onSubmit = nil
+			return
+		}
+		s.queue = append([]*scheduledStatement{st}, s.queue...)
+		s.start()
+	}()
+}
+
+// status returns the last known status of a tracked statement. ok is false if the scheduler
+// does not know about the statement, in which case its status must be retrieved directly.
+func (s *scheduler) status(queryID string) (status *api.ExecuteQueryStatus, err error, ok bool) {
+	if s == nil {
+		return nil, nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.statements[queryID]
+	if !ok {
+		return nil, nil, false
+	}
+	if st.status == nil {
+		// submitted but not polled yet
+		return &api.ExecuteQueryStatus{ID: queryID, State: string(redshiftdatatypes.StatusStringSubmitted)}, nil, true
+	}
+	res := *st.status
+	return &res, st.err, true
+}
+
+// lookup returns the ID of a tracked statement running the query that is still queued or running.
+// Finished statements are not returned, their results can only be reused through the result cache.
+func (s *scheduler) lookup(key string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict(time.Now())
+	queryID, ok := s.queries[key]
+	if !ok {
+		return "", false
+	}
+	st := s.statements[queryID]
+	if st.status != nil && st.status.Finished {
+		return "", false
+	}
+	return queryID, true
+}
+
+// statementID resolves a ticket to the Redshift statement ID
+func (s *scheduler) statementID(queryID string) string {
+	if s == nil || !strings.HasPrefix(queryID, queuedPrefix) {
+		return queryID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.statements[queryID]; ok && st.id != "" {
+		return st.id
+	}
+	return queryID
+}
+
+// whenSubmitted calls fn once the statement has been submitted, right away unless it is queued.
+// fn is not called for queued statements that are canceled or fail to be submitted.
+func (s *scheduler) whenSubmitted(queryID string, fn func()) {
+	if s == nil || !strings.HasPrefix(queryID, queuedPrefix) {
+		fn()
+		return
+	}
+	s.mu.Lock()
+	st, ok := s.statements[queryID]
+	if ok && st.id == "" && (st.status == nil || !st.status.Finished) {
+		st.onSubmit = append(st.onSubmit, fn)
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	if ok && st.id != "" {
+		fn()
+	}
+}
+
+// dequeue removes a statement that has not been submitted yet, returning false if it is not queued
+// or waiting for its cluster to resume
+func (s *scheduler) dequeue(queryID string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, st := range s.queue {
+		if st.ticket == queryID {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			st.finish(string(redshiftdatatypes.StatusStringAborted), nil)
+			return true
+		}
+	}
+	if st, ok := s.statements[queryID]; ok && st.resuming && !st.status.Finished {
+		st.finish(string(redshiftdatatypes.StatusStringAborted), nil)
+		return true
+	}
+	return false
+}
+
+func (s *scheduler) queueState(queryID string) (position int, depth int) {
+	if s == nil {
+		return 0, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, st := range s.queue {
+		if st.ticket == queryID {
+			position = i + 1
+		}
+	}
+	return position, len(s.queue)
+}
+
+// track starts polling a submitted statement. Must be called with the lock held.
+func (s *scheduler) track(st *scheduledStatement) {
+	st.interval = minPollInterval
+	st.nextPoll = time.Now().Add(st.interval)
+	s.statements[st.ticket] = st
+	s.queries[st.key] = st.ticket
+	s.start()
+}
+
+// release frees a slot. Must be called with the lock held.
+func (s *scheduler) release() {
+	s.inFlight--
+	s.signal()
+}
+
+// evict forgets statements that finished a while ago. Must be called with the lock held.
+func (s *scheduler) evict(now time.Time) {
+	for ticket, st := range s.statements {
+		if st.status != nil && st.status.Finished && now.Sub(st.finishedAt) > finishedRetention {
+			delete(s.statements, ticket)
+			if s.queries[st.key] == ticket {
+				delete(s.queries, st.key)
+			}
+		}
+	}
+}
+
+// close aborts the queued statements and stops polling. It is called when the datasource instance is
+// replaced: the statements queued on it would only start once nobody is waiting for their results anymore.
+func (s *scheduler) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for _, st := range s.queue {
+		st.finish(string(redshiftdatatypes.StatusStringAborted), errExpiredTicket)
+	}
+	s.queue = nil
+	for _, st := range s.statements {
+		if st.resuming && !st.status.Finished {
+			st.finish(string(redshiftdatatypes.StatusStringAborted), errExpiredTicket)
+		}
+	}
+	s.signal()
+}
+
+// start launches the polling loop if it is not running. Must be called with the lock held.
+func (s *scheduler) start() {
+	if s.closed {
+		return
+	}
+	if s.polling {
+		s.signal()
+		return
+	}
+	s.polling = true
+	go s.run()
+}
+
+func (s *scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run submits queued statements as slots become free and polls running statements,
+// backing off the interval of each one the longer it runs. It returns once there is nothing left to do.
+func (s *scheduler) run() {
+	for {
+		s.mu.Lock()
+		s.dispatch()
+		now := time.Now()
+		due := []*scheduledStatement{}
+		next := now.Add(maxPollInterval)
+		pending := len(s.queue)
+		for _, st := range s.statements {
+			if st.id == "" || (st.status != nil && st.status.Finished) {
+				continue
+			}
+			pending++
+			if !st.nextPoll.After(now) {
+				due = append(due, st)
+			} else if st.nextPoll.Before(next) {
+				next = st.nextPoll
+			}
+		}
+		if pending == 0 || s.closed {
+			s.polling = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, st := range due {
+			wg.Add(1)
+			go func(st *scheduledStatement) {
+				defer wg.Done()
+				s.poll(st)
+			}(st)
+		}
+		wg.Wait()
+		if len(due) > 0 {
+			continue
+		}
+
+		select {
+		case <-s.wake:
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// dispatch submits queued statements while there are free slots. Must be called with the lock held.
+func (s *scheduler) dispatch() {
+	for len(s.queue) > 0 && s.inFlight < s.maxInFlight {
+		st := s.queue[0]
+		s.queue = s.queue[1:]
+		s.inFlight++
+		go func(st *scheduledStatement) {
+			ctx, cancel := context.WithTimeout(st.ctx, pollTimeout)
+			defer cancel()
+			id, err := st.submit(ctx)
+			s.mu.Lock()
+			var resuming *resumingError
+			if errors.As(err, &resuming) && !st.status.Finished {
+				s.release()
+				s.waitForResume(st, resuming)
+				s.mu.Unlock()
+				return
+			}
+			if err != nil {
+				st.finish(string(redshiftdatatypes.StatusStringFailed), err)
+				st.onSubmit = nil
+				s.release()
+				s.mu.Unlock()
+				return
+			}
+			st.id = id
+			st.status = nil
+			s.track(st)
+			onSubmit := st.onSubmit
+			st.onSubmit = nil
+			s.mu.Unlock()
+			for _, fn := range onSubmit {
+				fn()
+			}
+		}(st)
+	}
+}
+
+func (s *scheduler) poll(st *scheduledStatement) {
+	ctx, cancel := context.WithTimeout(st.ctx, pollTimeout)
+	defer cancel()
+	status, err := st.api.describeStatus(ctx, st.id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.interval = min(time.Duration(float64(st.interval)*pollIntervalRate), maxPollInterval)
+	st.nextPoll = time.Now().Add(st.interval)
+	if err != nil && status == nil {
+		st.pollErrors++
+		if st.pollErrors < s.maxPollErrors {
+			// the status could not be retrieved, try again later
+			backend.Logger.Debug("failed to poll statement status", "queryID", st.id, "error", err)
+			return
+		}
+		backend.Logger.Warn("giving up on a statement whose status cannot be retrieved", "queryID", st.id, "error", err)
+		st.finish(string(redshiftdatatypes.StatusStringFailed), fmt.Errorf("failed to get the status of the statement: %w", err))
+		s.release()
+		st.api.statementFinished(st.id, st.key, false)
+		return
+	}
+	st.pollErrors = 0
+	status.ID = st.ticket
+	st.status = status
+	st.err = err
+	if status.Finished {
+		st.finishedAt = time.Now()
+		s.release()
+		st.api.statementFinished(st.id, st.key, err == nil && status.State == string(redshiftdatatypes.StatusStringFinished))
+	}
+}
+
+func newTicket() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return queuedPrefix + hex.EncodeToString(b)
+}
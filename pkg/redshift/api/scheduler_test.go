@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftdata"
+	redshiftdatatypes "github.com/aws/aws-sdk-go-v2/service/redshiftdata/types"
+	sqlAPI "github.com/grafana/grafana-aws-sdk/pkg/sql/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/api/mock"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+type statementsClient struct {
+	mock.MockRedshiftClient
+	mu        sync.Mutex
+	executed  int
+	described int
+	statuses  map[string]redshiftdatatypes.StatusString
+}
+
+func (c *statementsClient) ExecuteStatement(_ context.Context, _ *redshiftdata.ExecuteStatementInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.ExecuteStatementOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.executed++
+	id := fmt.Sprintf("statement-%d", c.executed)
+	c.statuses[id] = redshiftdatatypes.StatusStringStarted
+	return &redshiftdata.ExecuteStatementOutput{Id: aws.String(id)}, nil
+}
+
+func (c *statementsClient) DescribeStatement(_ context.Context, input *redshiftdata.DescribeStatementInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.DescribeStatementOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.described++
+	return &redshiftdata.DescribeStatementOutput{Id: input.Id, Status: c.statuses[*input.Id]}, nil
+}
+
+func (c *statementsClient) finish(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statuses[id] = redshiftdatatypes.StatusStringFinished
+}
+
+func Test_scheduler(t *testing.T) {
+	client := &statementsClient{statuses: map[string]redshiftdatatypes.StatusString{}}
+	c := &API{
+		settings:   &models.RedshiftDataSourceSettings{},
+		DataClient: client,
+		scheduler:  newScheduler(1),
+	}
+
+	first, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 1"})
+	require.NoError(t, err)
+	assert.Equal(t, "statement-1", first.ID)
+
+	second, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 2"})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(second.ID, queuedPrefix))
+	position, depth := c.scheduler.queueState(second.ID)
+	assert.Equal(t, 1, position)
+	assert.Equal(t, 1, depth)
+
+	status, err := c.Status(context.Background(), second)
+	require.NoError(t, err)
+	assert.Equal(t, string(redshiftdatatypes.StatusStringSubmitted), status.State)
+
+	client.finish("statement-1")
+	require.Eventually(t, func() bool {
+		status, err := c.Status(context.Background(), first)
+		return err == nil && status.Finished
+	}, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return c.StatementID(second.ID) == "statement-2"
+	}, time.Second, 10*time.Millisecond)
+
+	client.finish("statement-2")
+	require.Eventually(t, func() bool {
+		status, err := c.Status(context.Background(), second)
+		return err == nil && status.Finished && status.ID == second.ID
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_scheduler_cancelQueued(t *testing.T) {
+	client := &statementsClient{statuses: map[string]redshiftdatatypes.StatusString{}}
+	c := &API{
+		settings:   &models.RedshiftDataSourceSettings{},
+		DataClient: client,
+		scheduler:  newScheduler(1),
+	}
+	_, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 1"})
+	require.NoError(t, err)
+	queued, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 2"})
+	require.NoError(t, err)
+
+	require.NoError(t, c.Stop(queued))
+	status, err := c.Status(context.Background(), queued)
+	require.NoError(t, err)
+	assert.True(t, status.Finished)
+	assert.Equal(t, string(redshiftdatatypes.StatusStringAborted), status.State)
+	_, depth := c.scheduler.queueState(queued.ID)
+	assert.Equal(t, 0, depth)
+}
+
+func Test_GetQueryID(t *testing.T) {
+	client := &statementsClient{statuses: map[string]redshiftdatatypes.StatusString{}}
+	c := &API{
+		settings:   &models.RedshiftDataSourceSettings{},
+		DataClient: client,
+		scheduler:  newScheduler(1),
+	}
+	found, _, err := c.GetQueryID(context.Background(), "select 1")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	running, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 1"})
+	require.NoError(t, err)
+	found, id, err := c.GetQueryID(context.Background(), "select 1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, running.ID, id)
+
+	queued, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 2"})
+	require.NoError(t, err)
+	found, id, err = c.GetQueryID(context.Background(), "select 2")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, queued.ID, id)
+
+	require.NoError(t, c.Stop(queued))
+	found, _, err = c.GetQueryID(context.Background(), "select 2")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// finished statements are not attached to without the result cache
+	client.finish(running.ID)
+	require.Eventually(t, func() bool {
+		status, err := c.Status(context.Background(), running)
+		return err == nil && status.Finished
+	}, time.Second, 10*time.Millisecond)
+	found, _, err = c.GetQueryID(context.Background(), "select 1")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	c.results = newResultCache(time.Minute)
+	c.results.add(c.queryKey(c.apiInput(), "select 1"), running.ID)
+	found, id, err = c.GetQueryID(context.Background(), "select 1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, running.ID, id)
+}
+
+// unreachableClient submits statements but cannot retrieve their status
+type unreachableClient struct {
+	statementsClient
+}
+
+func (c *unreachableClient) DescribeStatement(_ context.Context, _ *redshiftdata.DescribeStatementInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.DescribeStatementOutput, error) {
+	return nil, fmt.Errorf("connection reset")
+}
+
+func Test_scheduler_pollErrors(t *testing.T) {
+	client := &unreachableClient{statementsClient{statuses: map[string]redshiftdatatypes.StatusString{}}}
+	c := &API{
+		settings:   &models.RedshiftDataSourceSettings{},
+		DataClient: client,
+		scheduler:  newScheduler(1),
+	}
+	c.scheduler.maxPollErrors = 2
+	first, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 1"})
+	require.NoError(t, err)
+	queued, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 2"})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		status, err := c.Status(context.Background(), first)
+		return err != nil && status.Finished && status.State == string(redshiftdatatypes.StatusStringFailed)
+	}, 5*time.Second, 10*time.Millisecond)
+	// the slot of the failed statement is released
+	require.Eventually(t, func() bool {
+		return c.StatementID(queued.ID) == "statement-2"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_scheduler_close(t *testing.T) {
+	client := &statementsClient{statuses: map[string]redshiftdatatypes.StatusString{}}
+	c := &API{
+		settings:   &models.RedshiftDataSourceSettings{},
+		DataClient: client,
+		scheduler:  newScheduler(1),
+	}
+	_, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 1"})
+	require.NoError(t, err)
+	queued, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 2"})
+	require.NoError(t, err)
+
+	c.scheduler.close()
+	status, err := c.Status(context.Background(), queued)
+	assert.ErrorIs(t, err, errExpiredTicket)
+	assert.True(t, status.Finished)
+	_, depth := c.scheduler.queueState(queued.ID)
+	assert.Equal(t, 0, depth)
+
+	// the instance replacing the closed one does not know the ticket
+	next := &API{settings: c.settings, DataClient: client, scheduler: newScheduler(1)}
+	_, err = next.Status(context.Background(), queued)
+	assert.ErrorIs(t, err, errExpiredTicket)
+
+	// closed schedulers submit statements directly
+	res, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 3"})
+	require.NoError(t, err)
+	assert.Equal(t, "statement-2", res.ID)
+}
+
+func Test_scheduler_whenSubmitted(t *testing.T) {
+	client := &statementsClient{statuses: map[string]redshiftdatatypes.StatusString{}}
+	c := &API{
+		settings:   &models.RedshiftDataSourceSettings{},
+		DataClient: client,
+		scheduler:  newScheduler(1),
+	}
+	first, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 1"})
+	require.NoError(t, err)
+	queued, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 2"})
+	require.NoError(t, err)
+	canceled, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 3"})
+	require.NoError(t, err)
+
+	submitted := make(chan string, 3)
+	for _, id := range []string{first.ID, queued.ID, canceled.ID} {
+		c.WhenSubmitted(id, func() { submitted <- id })
+	}
+	assert.Equal(t, first.ID, <-submitted)
+	require.NoError(t, c.Stop(canceled))
+	assert.Empty(t, submitted)
+
+	client.finish("statement-1")
+	select {
+	case id := <-submitted:
+		assert.Equal(t, queued.ID, id)
+		assert.Equal(t, "statement-2", c.StatementID(queued.ID))
+	case <-time.After(time.Second):
+		t.Fatal("the queued statement was not submitted")
+	}
+	assert.Empty(t, submitted)
+}
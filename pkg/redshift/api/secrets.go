@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	secretsmanagertypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/api/types"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+// secretCheckInterval is how often the managed secret of a datasource is checked for rotation
+const secretCheckInterval = 5 * time.Minute
+
+// defaultSecretTagKey is the tag of the secrets the query editor of the AWS console can use, listed when the
+// datasource has no discovery filters
+// https://docs.aws.amazon.com/redshift/latest/mgmt/query-editor.html#query-cluster-configure
+const defaultSecretTagKey = "RedshiftQueryOwner"
+
+// fullSecretARN matches complete secret ARNs, which end with the random suffix Secrets Manager adds to the name
+var fullSecretARN = regexp.MustCompile(`^arn:[^:]+:secretsmanager:[^:]+:\d{12}:secret:.+-[A-Za-z0-9]{6}$`)
+
+// managedSecret resolves the name or partial ARN of a secret to the ARN the Data API expects, and
+// detects when the secret is rotated or replaced by checking its current version
+type managedSecret struct {
+	ref string
+
+	mu      sync.Mutex
+	arn     string
+	version string
+	checked time.Time
+}
+
+func (s *managedSecret) ARN() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.arn
+}
+
+// due returns true if the secret was not checked recently
+func (s *managedSecret) due() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.checked) >= secretCheckInterval
+}
+
+// refresh describes the secret, unless it was checked recently, and returns true if its current
+// version or ARN changed since the last check. The secret is described without holding the lock,
+// so that queries reading the ARN meanwhile are not blocked, and by a single caller at a time.
+func (s *managedSecret) refresh(ctx context.Context, client types.RedshiftSecretsClient) (bool, error) {
+	s.mu.Lock()
+	if time.Since(s.checked) < secretCheckInterval {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.checked = time.Now()
+	s.mu.Unlock()
+
+	out, err := client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(s.ref)})
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	arn, version := aws.ToString(out.ARN), currentVersion(out.VersionIdsToStages)
+	rotated := s.version != "" && (version != s.version || arn != s.arn)
+	if arn != "" {
+		s.arn = arn
+	}
+	s.version = version
+	return rotated, nil
+}
+
+// currentVersion returns the ID of the version of a secret labeled AWSCURRENT
+func currentVersion(versions map[string][]string) string {
+	for id, stages := range versions {
+		if slices.Contains(stages, "AWSCURRENT") {
+			return id
+		}
+	}
+	return ""
+}
+
+// resolveSecret looks up the full ARN of the managed secret when it is configured by name or partial ARN.
+// The secret is kept as configured if it cannot be described, the Data API reports the error then.
+func (c *API) resolveSecret(ctx context.Context) {
+	if c.secret == nil || fullSecretARN.MatchString(c.secret.ref) {
+		return
+	}
+	if _, err := c.secret.refresh(ctx, c.SecretsClient); err != nil {
+		backend.Logger.Warn("failed to look up the managed secret", "secret", c.secret.ref, "error", err)
+	}
+}
+
+// checkSecretRotation drops the sessions of the datasource once its managed secret is rotated, since they
+// are connected with the previous credentials. The secret is described in the background, queries do not
+// wait for it. Only secrets configured by name or partial ARN are checked: they are described anyway to
+// resolve their ARN, while secrets configured by full ARN would need secretsmanager:DescribeSecret for it.
+func (c *API) checkSecretRotation(ctx context.Context) {
+	if c.secret == nil || fullSecretARN.MatchString(c.secret.ref) || !c.secret.due() {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		rotated, err := c.secret.refresh(ctx, c.SecretsClient)
+		if err != nil {
+			backend.Logger.Warn("failed to check the managed secret for rotation", "secret", c.secret.ref, "error", err)
+			return
+		}
+		if rotated {
+			backend.Logger.Info("managed secret rotated, closing sessions", "secret", c.secret.ref)
+			c.sessions.evict()
+		}
+	}()
+}
+
+// secretARN returns the ARN of the managed secret of the datasource
+func (c *API) secretARN() string {
+	if c.secret != nil {
+		return c.secret.ARN()
+	}
+	return c.settings.ManagedSecret.ARN
+}
+
+// secretFilters returns the filters secrets are listed with. Secrets must match every kind of filter
+// set, and one of the values of each.
+func secretFilters(settings *models.RedshiftDataSourceSettings) []secretsmanagertypes.Filter {
+	if len(settings.SecretTagKeys) == 0 && len(settings.SecretTagValues) == 0 && len(settings.SecretNamePrefixes) == 0 {
+		return []secretsmanagertypes.Filter{{Key: secretsmanagertypes.FilterNameStringTypeTagKey, Values: []string{defaultSecretTagKey}}}
+	}
+	filters := []secretsmanagertypes.Filter{}
+	for key, values := range map[secretsmanagertypes.FilterNameStringType][]string{
+		secretsmanagertypes.FilterNameStringTypeTagKey:   settings.SecretTagKeys,
+		secretsmanagertypes.FilterNameStringTypeTagValue: settings.SecretTagValues,
+		secretsmanagertypes.FilterNameStringTypeName:     settings.SecretNamePrefixes,
+	} {
+		if len(values) > 0 {
+			filters = append(filters, secretsmanagertypes.Filter{Key: key, Values: values})
+		}
+	}
+	slices.SortFunc(filters, func(a, b secretsmanagertypes.Filter) int { return strings.Compare(string(a.Key), string(b.Key)) })
+	return filters
+}
+
+func secretTags(tags []secretsmanagertypes.Tag) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		res[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return res
+}
+
+// extraSecrets describes the extra secrets of the datasource that are not listed already. Secrets of other
+// accounts cannot always be described, they are returned with their ARN only.
+func (c *API) extraSecrets(ctx context.Context, listed []models.ManagedSecret) []models.ManagedSecret {
+	res := []models.ManagedSecret{}
+	for _, arn := range c.settings.SecretARNs {
+		if slices.ContainsFunc(listed, func(s models.ManagedSecret) bool { return s.ARN == arn }) {
+			continue
+		}
+		out, err := c.SecretsClient.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(arn)})
+		if err != nil {
+			backend.Logger.Debug("failed to describe secret", "secret", arn, "error", err)
+			res = append(res, models.ManagedSecret{ARN: arn, Name: secretName(arn)})
+			continue
+		}
+		res = append(res, models.ManagedSecret{
+			ARN:             arn,
+			Name:            aws.ToString(out.Name),
+			Description:     aws.ToString(out.Description),
+			Tags:            secretTags(out.Tags),
+			LastRotatedDate: out.LastRotatedDate,
+		})
+	}
+	return res
+}
+
+// secretName returns the name of a secret from its ARN, including the suffix Secrets Manager adds to it
+func secretName(arn string) string {
+	if i := strings.Index(arn, ":secret:"); i >= 0 {
+		return arn[i+len(":secret:"):]
+	}
+	return arn
+}
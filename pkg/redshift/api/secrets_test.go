@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	secretsmanagertypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/sqlds/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/api/mock"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+const rotatedSecretARN = "arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/redshift-AbCdEf"
+
+// fakeSecretsClient serves a single secret, by name or ARN
+type fakeSecretsClient struct {
+	mock.MockRedshiftSecretsManager
+	mu        sync.Mutex
+	version   string
+	binary    []byte
+	described int
+}
+
+func (c *fakeSecretsClient) DescribeSecret(_ context.Context, _ *secretsmanager.DescribeSecretInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.described++
+	return &secretsmanager.DescribeSecretOutput{
+		ARN:                aws.String(rotatedSecretARN),
+		VersionIdsToStages: map[string][]string{c.version: {"AWSCURRENT"}, "previous": {"AWSPREVIOUS"}},
+	}, nil
+}
+
+func (c *fakeSecretsClient) GetSecretValue(_ context.Context, _ *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	return &secretsmanager.GetSecretValueOutput{SecretBinary: c.binary}, nil
+}
+
+func Test_managedSecret_byName(t *testing.T) {
+	settings := &models.RedshiftDataSourceSettings{
+		Config:            backend.DataSourceInstanceSettings{ID: 47},
+		ClusterIdentifier: "prod",
+		UseManagedSecret:  true,
+		ManagedSecret:     models.ManagedSecret{Name: "prod/redshift"},
+	}
+	client := &fakeSecretsClient{version: "v1"}
+	c := &API{settings: settings, SecretsClient: client, secret: NewDatasourceState().managedSecret(settings)}
+	c.resolveSecret(context.Background())
+	assert.Equal(t, rotatedSecretARN, aws.ToString(c.apiInput().SecretARN))
+
+	// full ARNs are not looked up
+	settings = &models.RedshiftDataSourceSettings{
+		Config:           backend.DataSourceInstanceSettings{ID: 47},
+		UseManagedSecret: true,
+		ManagedSecret:    models.ManagedSecret{ARN: rotatedSecretARN},
+	}
+	client = &fakeSecretsClient{version: "v1"}
+	c = &API{settings: settings, SecretsClient: client, secret: NewDatasourceState().managedSecret(settings)}
+	c.resolveSecret(context.Background())
+	assert.Equal(t, 0, client.described)
+	assert.Equal(t, rotatedSecretARN, c.secretARN())
+}
+
+func Test_checkSecretRotation(t *testing.T) {
+	settings := &models.RedshiftDataSourceSettings{
+		Config:           backend.DataSourceInstanceSettings{ID: 48},
+		UseManagedSecret: true,
+		ManagedSecret:    models.ManagedSecret{ARN: "prod/redshift"},
+	}
+	client := &fakeSecretsClient{version: "v1"}
+	pool := newSessionPool(time.Minute, 5)
+	c := &API{settings: settings, SecretsClient: client, secret: NewDatasourceState().managedSecret(settings), sessions: pool}
+	described := func() int {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.described
+	}
+	idle := func() int {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return len(pool.idle["key"])
+	}
+	c.checkSecretRotation(context.Background())
+	assert.Eventually(t, func() bool { return described() == 1 && c.secretARN() == rotatedSecretARN }, time.Second, time.Millisecond)
+	pool.checkout("statement", &session{id: "session", key: "key"})
+	pool.release("statement")
+	pool.checkout("running", &session{id: "busy", key: "key"})
+
+	// the secret is only checked again after the interval
+	client.mu.Lock()
+	client.version = "v2"
+	client.mu.Unlock()
+	c.checkSecretRotation(context.Background())
+	assert.Equal(t, 1, described())
+	assert.Equal(t, 1, idle())
+
+	c.secret.mu.Lock()
+	c.secret.checked = time.Time{}
+	c.secret.mu.Unlock()
+	c.checkSecretRotation(context.Background())
+	assert.Eventually(t, func() bool { return described() == 2 && idle() == 0 }, time.Second, time.Millisecond)
+	// the session running a statement during the rotation is not reused
+	pool.release("running")
+	assert.Equal(t, 0, idle())
+}
+
+func Test_checkSecretRotation_fullARN(t *testing.T) {
+	settings := &models.RedshiftDataSourceSettings{
+		Config:           backend.DataSourceInstanceSettings{ID: 48},
+		UseManagedSecret: true,
+		ManagedSecret:    models.ManagedSecret{ARN: rotatedSecretARN},
+	}
+	client := &fakeSecretsClient{version: "v1"}
+	c := &API{settings: settings, SecretsClient: client, secret: NewDatasourceState().managedSecret(settings)}
+	c.checkSecretRotation(context.Background())
+	assert.True(t, c.secret.due())
+	assert.Equal(t, 0, client.described)
+}
+
+// blockingSecretsClient describes the secret once it is unblocked
+type blockingSecretsClient struct {
+	fakeSecretsClient
+	describing chan struct{}
+	unblock    chan struct{}
+}
+
+func (c *blockingSecretsClient) DescribeSecret(ctx context.Context, input *secretsmanager.DescribeSecretInput, options ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+	close(c.describing)
+	<-c.unblock
+	return c.fakeSecretsClient.DescribeSecret(ctx, input, options...)
+}
+
+func Test_managedSecret_refreshDoesNotBlock(t *testing.T) {
+	secret := &managedSecret{ref: "prod/redshift", arn: "prod/redshift"}
+	client := &blockingSecretsClient{fakeSecretsClient: fakeSecretsClient{version: "v1"}, describing: make(chan struct{}), unblock: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := secret.refresh(context.Background(), client)
+		assert.NoError(t, err)
+	}()
+	<-client.describing
+
+	// the ARN can be read, and other callers do not describe the secret again, while it is described
+	assert.Equal(t, "prod/redshift", secret.ARN())
+	rotated, err := secret.refresh(context.Background(), client)
+	assert.NoError(t, err)
+	assert.False(t, rotated)
+
+	close(client.unblock)
+	<-done
+	assert.Equal(t, rotatedSecretARN, secret.ARN())
+}
+
+func Test_Secret_binary(t *testing.T) {
+	c := &API{SecretsClient: &fakeSecretsClient{binary: []byte(`{"dbClusterIdentifier":"foo","username":"bar"}`)}}
+	secret, err := c.Secret(context.Background(), sqlds.Options{"secretARN": "prod/redshift"})
+	require.NoError(t, err)
+	assert.Equal(t, &models.RedshiftSecret{ClusterIdentifier: "foo", DBUser: "bar"}, secret)
+}
+
+// listingSecretsClient records the filters secrets are listed with
+type listingSecretsClient struct {
+	fakeSecretsClient
+	filters []secretsmanagertypes.Filter
+}
+
+func (c *listingSecretsClient) ListSecrets(_ context.Context, input *secretsmanager.ListSecretsInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.ListSecretsOutput, error) {
+	c.filters = input.Filters
+	return &secretsmanager.ListSecretsOutput{SecretList: []secretsmanagertypes.SecretListEntry{{
+		ARN:             aws.String("arn:platform/redshift"),
+		Name:            aws.String("platform/redshift"),
+		Description:     aws.String("read-only user"),
+		Tags:            []secretsmanagertypes.Tag{{Key: aws.String("team"), Value: aws.String("platform")}},
+		LastRotatedDate: aws.Time(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}}}, nil
+}
+
+func Test_Secrets_discovery(t *testing.T) {
+	client := &listingSecretsClient{}
+	c := &API{settings: &models.RedshiftDataSourceSettings{}, SecretsClient: client}
+	_, err := c.Secrets(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []secretsmanagertypes.Filter{{Key: secretsmanagertypes.FilterNameStringTypeTagKey, Values: []string{"RedshiftQueryOwner"}}}, client.filters)
+
+	c.settings = &models.RedshiftDataSourceSettings{
+		SecretTagKeys:      []string{"team"},
+		SecretNamePrefixes: []string{"platform/"},
+		SecretARNs:         []string{"arn:platform/redshift", rotatedSecretARN},
+	}
+	secrets, err := c.Secrets(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []secretsmanagertypes.Filter{
+		{Key: secretsmanagertypes.FilterNameStringTypeName, Values: []string{"platform/"}},
+		{Key: secretsmanagertypes.FilterNameStringTypeTagKey, Values: []string{"team"}},
+	}, client.filters)
+	require.Len(t, secrets, 2)
+	assert.Equal(t, models.ManagedSecret{
+		Name:            "platform/redshift",
+		ARN:             "arn:platform/redshift",
+		Description:     "read-only user",
+		Tags:            map[string]string{"team": "platform"},
+		LastRotatedDate: aws.Time(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}, secrets[0])
+	// extra secrets that are not listed are described
+	assert.Equal(t, rotatedSecretARN, secrets[1].ARN)
+	assert.Equal(t, 1, client.described)
+}
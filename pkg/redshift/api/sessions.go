@@ -0,0 +1,144 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+const (
+	defaultSessionKeepAlive = 5 * time.Minute
+	defaultSessionPoolSize  = 5
+	// sessions about to expire are not reused to avoid racing against their expiration
+	sessionExpiryMargin = 10 * time.Second
+)
+
+// configuredSessionPool returns a pool configured with the settings, or nil if session reuse is disabled
+func configuredSessionPool(settings *models.RedshiftDataSourceSettings) *sessionPool {
+	if !settings.UseSessionPool {
+		return nil
+	}
+	keepAlive := defaultSessionKeepAlive
+	if settings.SessionKeepAliveSeconds > 0 {
+		keepAlive = time.Duration(settings.SessionKeepAliveSeconds) * time.Second
+	}
+	size := defaultSessionPoolSize
+	if settings.SessionPoolSize > 0 {
+		size = settings.SessionPoolSize
+	}
+	return newSessionPool(keepAlive, size)
+}
+
+// session is a Data API session. A session runs one statement at a time.
+type session struct {
+	id      string
+	key     string
+	expires time.Time
+	// set when the credentials the session is connected with changed while it was running a statement
+	stale bool
+}
+
+// sessionPool keeps idle Data API sessions so that statements can reuse them instead
+// of opening a new connection each time. Sessions are grouped by connection target.
+type sessionPool struct {
+	keepAlive time.Duration
+	size      int
+
+	mu   sync.Mutex
+	idle map[string][]*session
+	// sessions running a statement, by statement ID
+	busy map[string]*session
+}
+
+func newSessionPool(keepAlive time.Duration, size int) *sessionPool {
+	return &sessionPool{
+		keepAlive: keepAlive,
+		size:      size,
+		idle:      map[string][]*session{},
+		busy:      map[string]*session{},
+	}
+}
+
+// sessionKey identifies the target and identity a session is connected with
+func sessionKey(input apiInput) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s/%s",
+		aws.ToString(input.ClusterIdentifier),
+		aws.ToString(input.WorkgroupName),
+		aws.ToString(input.Database),
+		aws.ToString(input.DbUser),
+		aws.ToString(input.SecretARN),
+		input.User,
+	)
+}
+
+// keepAliveSeconds is the time a new session is kept alive after each statement
+func (p *sessionPool) keepAliveSeconds() *int32 {
+	return aws.Int32(int32(p.keepAlive / time.Second))
+}
+
+// acquire takes an idle session for the key, returning nil if there is none
+func (p *sessionPool) acquire(key string) *session {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for len(p.idle[key]) > 0 {
+		sessions := p.idle[key]
+		s := sessions[len(sessions)-1]
+		p.idle[key] = sessions[:len(sessions)-1]
+		if now.Add(sessionExpiryMargin).Before(s.expires) {
+			return s
+		}
+	}
+	return nil
+}
+
+// checkout marks the session as running the statement
+func (p *sessionPool) checkout(statementID string, s *session) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busy[statementID] = s
+}
+
+// release returns the session used by a finished statement to the pool.
+// The session expires once its keep alive time passes without running another statement.
+func (p *sessionPool) release(statementID string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.busy[statementID]
+	if !ok {
+		return
+	}
+	delete(p.busy, statementID)
+	if s.stale || len(p.idle[s.key]) >= p.size {
+		// let the session expire on its own
+		return
+	}
+	s.expires = time.Now().Add(p.keepAlive)
+	p.idle[s.key] = append(p.idle[s.key], s)
+}
+
+// evict drops the idle sessions, and those running a statement once it finishes. They are then left to expire.
+func (p *sessionPool) evict() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle = map[string][]*session{}
+	for _, s := range p.busy {
+		s.stale = true
+	}
+}
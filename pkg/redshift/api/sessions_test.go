@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftdata"
+	sqlAPI "github.com/grafana/grafana-aws-sdk/pkg/sql/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/api/mock"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+type sessionsClient struct {
+	mock.MockRedshiftClient
+	inputs       []*redshiftdata.ExecuteStatementInput
+	deadSessions bool
+}
+
+func (c *sessionsClient) ExecuteStatement(_ context.Context, input *redshiftdata.ExecuteStatementInput, _ ...func(*redshiftdata.Options)) (*redshiftdata.ExecuteStatementOutput, error) {
+	c.inputs = append(c.inputs, input)
+	if input.SessionId != nil {
+		if c.deadSessions {
+			return nil, errors.New("session is not available")
+		}
+		return &redshiftdata.ExecuteStatementOutput{Id: aws.String("reused"), SessionId: input.SessionId}, nil
+	}
+	return &redshiftdata.ExecuteStatementOutput{Id: aws.String("new"), SessionId: aws.String("session")}, nil
+}
+
+func Test_sessionPool(t *testing.T) {
+	tests := []struct {
+		description        string
+		deadSessions       bool
+		expectedID         string
+		expectedExecutions int
+	}{
+		{
+			description:        "reuses the session of a finished statement",
+			expectedID:         "reused",
+			expectedExecutions: 2,
+		},
+		{
+			description:        "opens a new session when the pooled one died",
+			deadSessions:       true,
+			expectedID:         "new",
+			expectedExecutions: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			client := &sessionsClient{deadSessions: tt.deadSessions}
+			c := &API{
+				settings:   &models.RedshiftDataSourceSettings{ClusterIdentifier: "cluster", Database: "db", DBUser: "user"},
+				DataClient: client,
+				sessions:   newSessionPool(time.Minute, 1),
+			}
+			first, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 1"})
+			require.NoError(t, err)
+			assert.Equal(t, int32(60), aws.ToInt32(client.inputs[0].SessionKeepAliveSeconds))
+			c.statementFinished(first.ID, "", true)
+
+			second, err := c.Execute(context.Background(), &sqlAPI.ExecuteQueryInput{Query: "select 2"})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedID, second.ID)
+			require.Len(t, client.inputs, tt.expectedExecutions)
+			reused := client.inputs[1]
+			assert.Equal(t, "session", aws.ToString(reused.SessionId))
+			assert.Nil(t, reused.ClusterIdentifier)
+			assert.Nil(t, reused.Database)
+		})
+	}
+}
+
+func Test_sessionPool_expiry(t *testing.T) {
+	pool := newSessionPool(time.Minute, 1)
+	pool.checkout("foo", &session{id: "session", key: "key"})
+	pool.release("foo")
+	pool.idle["key"][0].expires = time.Now()
+	assert.Nil(t, pool.acquire("key"))
+}
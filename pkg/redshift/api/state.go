@@ -0,0 +1,101 @@
+package api
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+// DatasourceState is the state shared by the API instances of a datasource instance: the retry budget,
+// the scheduler, the session pool, the result cache, the targets that ran statements, the identities
+// statements run with and the managed secrets. Grafana creates a new instance when the settings change,
+// so the state always matches the current settings, and disposes of the previous one, which must then be
+// closed.
+type DatasourceState struct {
+	mu          sync.Mutex
+	initialized bool
+	closed      bool
+	retryBudget *ratelimit.TokenRateLimit
+	scheduler   *scheduler
+	sessions    *sessionPool
+	results     *resultCache
+	targets     *statementLog
+	owners      *statementLog
+	// managed secrets by name or ARN, since the connection arguments of a query can select another secret
+	secrets map[string]*managedSecret
+}
+
+func NewDatasourceState() *DatasourceState {
+	return &DatasourceState{secrets: map[string]*managedSecret{}}
+}
+
+// init creates the state with the settings of the first API instance. The settings of the API instances
+// of a datasource instance only differ by the target, identity and database their queries connect to.
+func (s *DatasourceState) init(settings *models.RedshiftDataSourceSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.initialized {
+		return
+	}
+	s.initialized = true
+	s.retryBudget = retryBudget(settings)
+	s.scheduler = newScheduler(maxConcurrentStatements(settings))
+	s.sessions = configuredSessionPool(settings)
+	s.results = configuredResultCache(settings)
+	s.targets = newStatementLog()
+	s.owners = newStatementLog()
+}
+
+// managedSecret returns the managed secret the settings use, by name, partial or full ARN.
+// It returns nil if they do not use a managed secret.
+func (s *DatasourceState) managedSecret(settings *models.RedshiftDataSourceSettings) *managedSecret {
+	if !settings.UseManagedSecret {
+		return nil
+	}
+	ref := settings.ManagedSecret.ARN
+	if ref == "" {
+		ref = settings.ManagedSecret.Name
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[ref]
+	if !ok {
+		secret = &managedSecret{ref: ref, arn: ref}
+		s.secrets[ref] = secret
+	}
+	return secret
+}
+
+// QueueState returns the position of a query in the datasource queue (zero if it is not queued)
+// and the number of queries the datasource has waiting for a free slot
+func (s *DatasourceState) QueueState(queryID string) (position int, depth int) {
+	if s == nil {
+		return 0, 0
+	}
+	return s.scheduler.queueState(queryID)
+}
+
+// QueryTarget returns the target that ran a query of the datasource, or an empty string if it is unknown
+func (s *DatasourceState) QueryTarget(queryID string) string {
+	if s == nil {
+		return ""
+	}
+	return s.targets.get(s.scheduler.statementID(queryID))
+}
+
+// Close stops the scheduler, aborting the statements still queued. It is called once the datasource
+// instance has been replaced, the queries of the new instance do not use this state anymore.
+func (s *DatasourceState) Close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.scheduler.close()
+}
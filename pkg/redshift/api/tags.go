@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+const (
+	TagDashboardUID = "dashboardUID"
+	TagPanelID      = "panelID"
+	TagUser         = "user"
+	TagRuleUID      = "ruleUID"
+)
+
+// defaultStatementTags are used when tagging is enabled without choosing the tags
+var defaultStatementTags = []string{TagDashboardUID, TagPanelID, TagUser, TagRuleUID}
+
+// StatementTags identifies the Grafana context a statement is issued from
+type StatementTags struct {
+	DashboardUID string
+	PanelID      string
+	User         string
+	RuleUID      string
+}
+
+type statementTagsKey struct{}
+
+// WithStatementTags returns a context carrying the tags of the statements executed with it
+func WithStatementTags(ctx context.Context, tags StatementTags) context.Context {
+	return context.WithValue(ctx, statementTagsKey{}, tags)
+}
+
+func statementTagsFromContext(ctx context.Context) StatementTags {
+	tags, _ := ctx.Value(statementTagsKey{}).(StatementTags)
+	return tags
+}
+
+// StatementNamePrefix is the prefix of the name of every statement issued by a datasource
+func StatementNamePrefix(datasourceUID string) string {
+	return fmt.Sprintf("grafana:%s:", datasourceUID)
+}
+
+// pairs returns the configured tags that have a value as key=value pairs
+func (t StatementTags) pairs(enabled []string) []string {
+	if len(enabled) == 0 {
+		enabled = defaultStatementTags
+	}
+	values := map[string]string{
+		TagDashboardUID: t.DashboardUID,
+		TagPanelID:      t.PanelID,
+		TagUser:         t.User,
+		TagRuleUID:      t.RuleUID,
+	}
+	res := []string{}
+	for _, tag := range enabled {
+		if v := sanitizeTag(values[tag]); v != "" {
+			res = append(res, fmt.Sprintf("%s=%s", tag, v))
+		}
+	}
+	return res
+}
+
+// sanitizeTag replaces characters that could break out of a SQL comment or the statement name
+func sanitizeTag(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '-' || r == '_' || r == '.' || r == '@':
+			return r
+		}
+		return '_'
+	}, value)
+}
+
+// tagStatement names the statement after the datasource and, if tagging is enabled, adds the
+// Grafana context to its name and as a leading comment of the SQL. It returns the name and the SQL.
+func tagStatement(ctx context.Context, settings *models.RedshiftDataSourceSettings, sql string) (string, string) {
+	name := StatementNamePrefix(settings.Config.UID)
+	if !settings.TagStatements {
+		return name, sql
+	}
+	pairs := statementTagsFromContext(ctx).pairs(settings.StatementTags)
+	if len(pairs) == 0 {
+		return name, sql
+	}
+	name += strings.Join(pairs, ",")
+	return name, fmt.Sprintf("/* grafana %s */\n%s", strings.Join(pairs, " "), sql)
+}
+
+// queryGroupStatement returns the statement setting the WLM query group, if configured
+func queryGroupStatement(settings *models.RedshiftDataSourceSettings) []string {
+	if settings.QueryGroup == "" {
+		return nil
+	}
+	return []string{fmt.Sprintf("SET query_group TO '%s'", strings.ReplaceAll(settings.QueryGroup, "'", "''"))}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+func Test_tagStatement(t *testing.T) {
+	ctx := WithStatementTags(context.Background(), StatementTags{
+		DashboardUID: "dash",
+		PanelID:      "2",
+		User:         "admin */ drop",
+	})
+	tests := []struct {
+		description  string
+		settings     *models.RedshiftDataSourceSettings
+		expectedName string
+		expectedSQL  string
+	}{
+		{
+			description:  "only names the statement after the datasource when tagging is disabled",
+			settings:     &models.RedshiftDataSourceSettings{},
+			expectedName: "grafana:uid:",
+			expectedSQL:  "select 1",
+		},
+		{
+			description:  "adds every tag with a value",
+			settings:     &models.RedshiftDataSourceSettings{TagStatements: true},
+			expectedName: "grafana:uid:dashboardUID=dash,panelID=2,user=admin____drop",
+			expectedSQL:  "/* grafana dashboardUID=dash panelID=2 user=admin____drop */\nselect 1",
+		},
+		{
+			description:  "adds the configured tags",
+			settings:     &models.RedshiftDataSourceSettings{TagStatements: true, StatementTags: []string{TagPanelID}},
+			expectedName: "grafana:uid:panelID=2",
+			expectedSQL:  "/* grafana panelID=2 */\nselect 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			tt.settings.Config = backend.DataSourceInstanceSettings{UID: "uid"}
+			name, sql := tagStatement(ctx, tt.settings, "select 1")
+			assert.Equal(t, tt.expectedName, name)
+			assert.Equal(t, tt.expectedSQL, sql)
+		})
+	}
+}
+
+func Test_queryGroupStatement(t *testing.T) {
+	assert.Nil(t, queryGroupStatement(&models.RedshiftDataSourceSettings{}))
+	assert.Equal(t, []string{"SET query_group TO 'it''s'"}, queryGroupStatement(&models.RedshiftDataSourceSettings{QueryGroup: "it's"}))
+}
@@ -6,11 +6,16 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/redshiftdata"
 	"github.com/aws/aws-sdk-go-v2/service/redshiftserverless"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 type ExecuteStatementAPIClient interface {
 	ExecuteStatement(context.Context, *redshiftdata.ExecuteStatementInput, ...func(*redshiftdata.Options)) (*redshiftdata.ExecuteStatementOutput, error)
 }
+type BatchExecuteStatementAPIClient interface {
+	BatchExecuteStatement(context.Context, *redshiftdata.BatchExecuteStatementInput, ...func(*redshiftdata.Options)) (*redshiftdata.BatchExecuteStatementOutput, error)
+}
 type DescribeStatementAPIClient interface {
 	DescribeStatement(context.Context, *redshiftdata.DescribeStatementInput, ...func(*redshiftdata.Options)) (*redshiftdata.DescribeStatementOutput, error)
 }
@@ -25,21 +30,35 @@ type RedshiftDataClient interface {
 	redshiftdata.ListTablesAPIClient
 	redshiftdata.DescribeTableAPIClient
 	redshiftdata.GetStatementResultAPIClient
+	redshiftdata.ListStatementsAPIClient
 
 	ExecuteStatementAPIClient
+	BatchExecuteStatementAPIClient
 	DescribeStatementAPIClient
 	CancelStatementAPIClient
 }
 
 type RedshiftManagementClient interface {
 	redshift.DescribeClustersAPIClient
+	ResumeCluster(context.Context, *redshift.ResumeClusterInput, ...func(*redshift.Options)) (*redshift.ResumeClusterOutput, error)
 }
 
 type RedshiftSecretsClient interface {
 	secretsmanager.ListSecretsAPIClient
 	GetSecretValue(context.Context, *secretsmanager.GetSecretValueInput, ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+	DescribeSecret(context.Context, *secretsmanager.DescribeSecretInput, ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error)
 }
 
 type ServerlessAPIClient interface {
 	redshiftserverless.ListWorkgroupsAPIClient
+	GetWorkgroup(context.Context, *redshiftserverless.GetWorkgroupInput, ...func(*redshiftserverless.Options)) (*redshiftserverless.GetWorkgroupOutput, error)
+}
+
+type STSClient interface {
+	AssumeRole(context.Context, *sts.AssumeRoleInput, ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+	AssumeRoleWithWebIdentity(context.Context, *sts.AssumeRoleWithWebIdentityInput, ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error)
+}
+
+type OIDCClient interface {
+	CreateTokenWithIAM(context.Context, *ssooidc.CreateTokenWithIAMInput, ...func(*ssooidc.Options)) (*ssooidc.CreateTokenWithIAMOutput, error)
 }
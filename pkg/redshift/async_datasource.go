@@ -0,0 +1,192 @@
+package redshift
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/redshift-datasource/pkg/redshift/api"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+// AsyncDatasource wraps the async AWS datasource to add Redshift specific handling of query requests
+type AsyncDatasource struct {
+	*awsds.AsyncAWSDatasource
+	redshift    RedshiftDatasourceIface
+	state       *api.DatasourceState
+	queries     queryGroup
+	incremental *incrementalCache
+	split       *querySplitter
+}
+
+// NewAsyncAWSDatasource returns the async AWS datasource of the driver. Queries can select another
+// target or identity with their connection arguments, which then get a connection of their own.
+func NewAsyncAWSDatasource(s *RedshiftDatasource) *awsds.AsyncAWSDatasource {
+	ds := awsds.NewAsyncAWSDatasource(s)
+	ds.Completable = s
+	ds.EnableRowLimit = true
+	ds.EnableMultipleConnections = true
+	return ds
+}
+
+func NewAsyncDatasource(ds *awsds.AsyncAWSDatasource, redshift *RedshiftDatasource) *AsyncDatasource {
+	return &AsyncDatasource{AsyncAWSDatasource: ds, redshift: redshift, state: redshift.state, incremental: newIncrementalCache(), split: newQuerySplitter()}
+}
+
+// Dispose closes the state of the datasource instance once Grafana has replaced it
+func (ds *AsyncDatasource) Dispose() {
+	ds.state.Close()
+	ds.AsyncAWSDatasource.Dispose()
+}
+
+// queryMeta extends the custom metadata of async queries with the state of the datasource queue
+// and, for datasources with failover targets, the target that ran the query
+type queryMeta struct {
+	QueryID       string `json:"queryID"`
+	Status        string `json:"status"`
+	QueuePosition int    `json:"queuePosition,omitempty"`
+	QueueDepth    int    `json:"queueDepth"`
+	Target        string `json:"target,omitempty"`
+}
+
+// targetMeta is the custom metadata of the frames of other queries run on a datasource with failover targets
+type targetMeta struct {
+	Target string `json:"target"`
+}
+
+func (ds *AsyncDatasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx = api.WithStatementTags(ctx, statementTags(req))
+	ctx = api.WithIdentityToken(ctx, api.IdentityToken(req.GetHTTPHeader(backend.OAuthIdentityIDTokenHeaderName), req.GetHTTPHeader(backend.OAuthIdentityTokenHeaderName)))
+	queryData := ds.incremental.queryData(ds.split.queryData(ds.AsyncAWSDatasource.QueryData))
+	res := ds.queries.queryData(ctx, req, recordTarget(nodeGraphQueryData(ds.redshift.Explain, queryData)))
+	addQueueState(ds.state, res)
+	return res, nil
+}
+
+// CheckHealth runs the health check with the OAuth identity forwarded by Grafana, which identity propagation needs.
+// Invalid settings are reported without querying, with the invalid fields in the details of the result. Otherwise
+// the details hold the report of every step of the health check, and the settings the datasource ignores.
+func (ds *AsyncDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	warnings := []models.FieldError{}
+	if settings, ok := datasourceSettings(req.PluginContext); ok {
+		if err := settings.Validate(); err != nil {
+			return invalidSettingsResult(err), nil
+		}
+		warnings = settings.Warnings()
+	}
+	ctx = api.WithIdentityToken(ctx, api.IdentityToken(req.GetHTTPHeader(backend.OAuthIdentityIDTokenHeaderName), req.GetHTTPHeader(backend.OAuthIdentityTokenHeaderName)))
+	report, err := ds.redshift.CheckHealth(ctx)
+	if err != nil {
+		return &backend.CheckHealthResult{Status: backend.HealthStatusError, Message: err.Error()}, nil
+	}
+	report.Warnings = warnings
+	res := &backend.CheckHealthResult{Status: backend.HealthStatusOk, Message: "Data source is working"}
+	if len(warnings) > 0 {
+		res.Message += ", but some settings are ignored: " + warnings[0].Message
+	}
+	if err := report.Err(); err != nil {
+		res.Status, res.Message = backend.HealthStatusError, err.Error()
+	}
+	res.JSONDetails, _ = json.Marshal(report)
+	return res, nil
+}
+
+func invalidSettingsResult(err error) *backend.CheckHealthResult {
+	res := &backend.CheckHealthResult{Status: backend.HealthStatusError, Message: err.Error()}
+	var validationErr *models.ValidationError
+	if errors.As(err, &validationErr) {
+		res.JSONDetails, _ = json.Marshal(validationErr)
+	}
+	return res
+}
+
+// recordTarget wraps fn, which runs requests made of a single query, to add the target that ran the
+// query to the metadata of frames that have no custom metadata. Async query frames get it from addQueueState.
+func recordTarget(fn queryDataFunc) queryDataFunc {
+	return func(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		ctx, served := api.WithTargetRecorder(ctx)
+		res, err := fn(ctx, req)
+		target := served()
+		if err != nil || res == nil || target == "" {
+			return res, err
+		}
+		for _, r := range res.Responses {
+			for _, frame := range r.Frames {
+				if frame.Meta == nil {
+					frame.Meta = &data.FrameMeta{}
+				}
+				if frame.Meta.Custom == nil {
+					frame.Meta.Custom = targetMeta{Target: target}
+				}
+			}
+		}
+		return res, nil
+	}
+}
+
+// addQueueState adds the queue position and depth, and the target that ran the query, to the metadata of async query frames
+func addQueueState(state *api.DatasourceState, res *backend.QueryDataResponse) {
+	for _, r := range res.Responses {
+		for _, frame := range r.Frames {
+			if frame.Meta == nil || frame.Meta.Custom == nil {
+				continue
+			}
+			meta, ok := parseQueryMeta(frame.Meta)
+			if !ok {
+				continue
+			}
+			meta.QueuePosition, meta.QueueDepth = state.QueueState(meta.QueryID)
+			if meta.Target == "" {
+				meta.Target = state.QueryTarget(meta.QueryID)
+			}
+			frame.Meta.Custom = meta
+		}
+	}
+}
+
+func parseQueryMeta(frameMeta *data.FrameMeta) (queryMeta, bool) {
+	meta := queryMeta{}
+	b, err := json.Marshal(frameMeta.Custom)
+	if err != nil {
+		return meta, false
+	}
+	if err := json.Unmarshal(b, &meta); err != nil || meta.QueryID == "" {
+		return meta, false
+	}
+	return meta, true
+}
+
+// statementTags returns the Grafana context of the request, taken from the headers Grafana forwards
+func statementTags(req *backend.QueryDataRequest) api.StatementTags {
+	tags := api.StatementTags{
+		DashboardUID: req.GetHTTPHeader("X-Dashboard-Uid"),
+		PanelID:      req.GetHTTPHeader("X-Panel-Id"),
+		RuleUID:      req.GetHTTPHeader("X-Rule-Uid"),
+	}
+	if req.PluginContext.User != nil {
+		tags.User = req.PluginContext.User.Login
+	}
+	return tags
+}
+
+// datasourceSettings loads the settings of the datasource of a request
+func datasourceSettings(pCtx backend.PluginContext) (*models.RedshiftDataSourceSettings, bool) {
+	if pCtx.DataSourceInstanceSettings == nil {
+		return nil, false
+	}
+	settings := &models.RedshiftDataSourceSettings{}
+	if err := settings.Load(*pCtx.DataSourceInstanceSettings); err != nil {
+		return nil, false
+	}
+	return settings, true
+}
+
+// filtersTimeRange returns true for queries filtered by their time range
+func filtersTimeRange(q backend.DataQuery) bool {
+	query, err := awsds.GetQuery(q)
+	return err == nil && strings.Contains(query.RawSQL, "$__timeFilter(")
+}
@@ -33,12 +33,21 @@ type RedshiftDatasourceIface interface {
 	Secret(ctx context.Context, options sqlds.Options) (*models.RedshiftSecret, error)
 	Clusters(ctx context.Context, options sqlds.Options) ([]models.RedshiftCluster, error)
 	Workgroups(ctx context.Context, options sqlds.Options) ([]models.RedshiftWorkgroup, error)
+	History(ctx context.Context, options sqlds.Options) ([]models.RedshiftStatement, error)
+	Running(ctx context.Context, options sqlds.Options) ([]models.RedshiftStatement, error)
+	CancelStatements(ctx context.Context, options sqlds.Options) (*models.CancelResult, error)
+	Explain(ctx context.Context, query *sqlutil.Query) (*models.QueryPlan, error)
+	CheckHealth(ctx context.Context) (*models.HealthReport, error)
+	Permissions(ctx context.Context, options sqlds.Options) (*models.PermissionReport, error)
 }
 
-type Loader struct{}
+// Loader creates the API instances of a datasource instance, which share its state
+type Loader struct {
+	state *api.DatasourceState
+}
 
 func (l Loader) LoadAPI(ctx context.Context, settings sqlModels.Settings) (sqlAPI.AWSAPI, error) {
-	return api.New(ctx, settings)
+	return api.New(ctx, settings, l.state)
 }
 
 func (l Loader) LoadDriver(ctx context.Context, awsapi sqlAPI.AWSAPI) (awsDriver.Driver, error) {
@@ -55,10 +64,12 @@ func (l Loader) LoadSettings(ctx context.Context) sqlModels.Settings {
 
 type RedshiftDatasource struct {
 	awsDS datasource.AWSClient
+	state *api.DatasourceState
 }
 
 func New() *RedshiftDatasource {
-	return &RedshiftDatasource{awsDS: datasource.New(Loader{})}
+	state := api.NewDatasourceState()
+	return &RedshiftDatasource{awsDS: datasource.New(Loader{state: state}), state: state}
 }
 
 func (s *RedshiftDatasource) Settings(ctx context.Context, _ backend.DataSourceInstanceSettings) sqlds.DriverSettings {
@@ -80,6 +91,9 @@ func (s *RedshiftDatasource) Connect(ctx context.Context, config backend.DataSou
 	if err != nil {
 		return nil, err
 	}
+	if err := checkOverrides(config, args); err != nil {
+		return nil, err
+	}
 	args["updated"] = config.Updated.String()
 
 	return s.awsDS.GetDB(ctx, config.ID, args)
@@ -91,6 +105,9 @@ func (s *RedshiftDatasource) GetAsyncDB(ctx context.Context, config backend.Data
 	if err != nil {
 		return nil, err
 	}
+	if err := checkOverrides(config, args); err != nil {
+		return nil, err
+	}
 	args["updated"] = config.Updated.String()
 
 	return s.awsDS.GetAsyncDB(ctx, config.ID, args)
@@ -102,6 +119,11 @@ func (s *RedshiftDatasource) getApi(ctx context.Context, options sqlds.Options)
 	for key, val := range options {
 		args[key] = val
 	}
+	if config := backend.PluginConfigFromContext(ctx).DataSourceInstanceSettings; config != nil {
+		if err := checkOverrides(*config, args); err != nil {
+			return nil, err
+		}
+	}
 	// the updated time makes sure that we don't use a token for a stale version of the datasource
 	args["updated"] = datasource.GetDatasourceLastUpdatedTime(ctx)
 
@@ -113,6 +135,18 @@ func (s *RedshiftDatasource) getApi(ctx context.Context, options sqlds.Options)
 	return res.(*api.API), err
 }
 
+// checkOverrides returns an error if the arguments select a target or identity the datasource does not allow
+func checkOverrides(config backend.DataSourceInstanceSettings, args sqlds.Options) error {
+	settings := &models.RedshiftDataSourceSettings{}
+	if err := settings.Load(config); err != nil {
+		return err
+	}
+	if err := settings.CheckOverrides(args); err != nil {
+		return backend.DownstreamError(err)
+	}
+	return nil
+}
+
 func (s *RedshiftDatasource) Regions(ctx context.Context) ([]string, error) {
 	// This is not used. If regions are out of date, update them in the @grafana/aws-sdk-react package
 	return []string{}, nil
@@ -205,3 +239,61 @@ func (s *RedshiftDatasource) Workgroups(ctx context.Context, options sqlds.Optio
 	}
 	return api.Workgroups(ctx)
 }
+
+func (s *RedshiftDatasource) History(ctx context.Context, options sqlds.Options) ([]models.RedshiftStatement, error) {
+	api, err := s.getApi(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	return api.History(ctx, options)
+}
+
+func (s *RedshiftDatasource) Running(ctx context.Context, options sqlds.Options) ([]models.RedshiftStatement, error) {
+	api, err := s.getApi(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	return api.Running(ctx, options)
+}
+
+func (s *RedshiftDatasource) CancelStatements(ctx context.Context, options sqlds.Options) (*models.CancelResult, error) {
+	api, err := s.getApi(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	return api.CancelStatements(ctx, options)
+}
+
+func (s *RedshiftDatasource) Permissions(ctx context.Context, options sqlds.Options) (*models.PermissionReport, error) {
+	api, err := s.getApi(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	return api.Permissions(ctx)
+}
+
+// Explain returns the plan of a query, after expanding its macros. The connection arguments of the query select the database.
+func (s *RedshiftDatasource) Explain(ctx context.Context, query *sqlutil.Query) (*models.QueryPlan, error) {
+	options, err := sqlds.ParseOptions(query.ConnectionArgs)
+	if err != nil {
+		return nil, err
+	}
+	sql, err := sqlutil.Interpolate(query, s.Macros())
+	if err != nil {
+		return nil, backend.DownstreamError(err)
+	}
+	api, err := s.getApi(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	return api.Explain(ctx, sql)
+}
+
+// CheckHealth runs the steps of the health check of the datasource
+func (s *RedshiftDatasource) CheckHealth(ctx context.Context) (*models.HealthReport, error) {
+	api, err := s.getApi(ctx, sqlds.Options{})
+	if err != nil {
+		return nil, err
+	}
+	return api.CheckHealth(ctx), nil
+}
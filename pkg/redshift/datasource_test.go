@@ -13,10 +13,16 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/sqlds/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/redshift-datasource/pkg/redshift/api"
+	"github.com/grafana/redshift-datasource/pkg/redshift/fake"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
 )
 
 type mockClient struct {
 	wasCalledWith sqlds.Options
+	asyncDB       awsds.AsyncDB
 }
 
 func (m *mockClient) Init(config backend.DataSourceInstanceSettings) {}
@@ -26,7 +32,7 @@ func (m *mockClient) GetDB(ctx context.Context, id int64, options sqlds.Options)
 }
 func (m *mockClient) GetAsyncDB(ctx context.Context, id int64, options sqlds.Options) (awsds.AsyncDB, error) {
 	m.wasCalledWith = options
-	return nil, nil
+	return m.asyncDB, nil
 }
 func (m *mockClient) GetAPI(ctx context.Context, id int64, options sqlds.Options) (sqlAPI.AWSAPI, error) {
 	m.wasCalledWith = options
@@ -88,3 +94,111 @@ func TestDatabases(t *testing.T) {
 		assert.Equal(t, "", mc.wasCalledWith["updated"])
 	})
 }
+
+func TestOverrides(t *testing.T) {
+	mc := mockClient{}
+	ds := RedshiftDatasource{awsDS: &mc}
+	config := backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{"clusterIdentifier":"prod","dbUser":"grafana","allowedClusters":["dev","staging"]}`),
+	}
+
+	_, err := ds.GetAsyncDB(context.Background(), config, json.RawMessage(`{"clusterIdentifier":"staging"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "staging", mc.wasCalledWith["clusterIdentifier"])
+
+	_, err = ds.GetAsyncDB(context.Background(), config, json.RawMessage(`{"clusterIdentifier":"other"}`))
+	assert.ErrorContains(t, err, `the cluster "other" is not allowed by the datasource`)
+
+	_, err = ds.Connect(context.Background(), config, json.RawMessage(`{"dbUser":"admin"}`))
+	assert.ErrorContains(t, err, `the database user "admin" is not allowed by the datasource`)
+}
+
+// startedDB starts every query as the same statement
+type startedDB struct {
+	awsds.AsyncDB
+}
+
+func (startedDB) GetQueryID(context.Context, string, ...interface{}) (bool, string, error) {
+	return false, "", nil
+}
+
+func (startedDB) StartQuery(context.Context, string, ...interface{}) (string, error) {
+	return "statement-1", nil
+}
+
+func TestQueryData_overrides(t *testing.T) {
+	mc := &mockClient{asyncDB: startedDB{}}
+	s := &RedshiftDatasource{awsDS: mc, state: api.NewDatasourceState()}
+	config := backend.DataSourceInstanceSettings{
+		UID:      "redshift",
+		JSONData: json.RawMessage(`{"clusterIdentifier":"prod","dbUser":"grafana","allowedClusters":["dev","staging"]}`),
+	}
+	awsDS := NewAsyncAWSDatasource(s)
+	_, err := awsDS.NewDatasource(context.Background(), config)
+	require.NoError(t, err)
+	ds := NewAsyncDatasource(awsDS, s)
+
+	query := func(connectionArgs string) backend.DataResponse {
+		res, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &config},
+			Queries: []backend.DataQuery{{
+				RefID: "A",
+				JSON:  json.RawMessage(`{"rawSQL":"select 1","meta":{"queryFlow":"async"},"connectionArgs":` + connectionArgs + `}`),
+			}},
+		})
+		require.NoError(t, err)
+		return res.Responses["A"]
+	}
+
+	res := query(`{"clusterIdentifier":"staging"}`)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, "staging", mc.wasCalledWith["clusterIdentifier"])
+
+	res = query(`{"clusterIdentifier":"other"}`)
+	assert.ErrorContains(t, res.Error, `the cluster "other" is not allowed by the datasource`)
+}
+
+func TestCheckHealth_invalidSettings(t *testing.T) {
+	ds := &AsyncDatasource{}
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+			JSONData: json.RawMessage(`{"useServerless":true,"database":"dev"}`),
+		}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusError, res.Status)
+	assert.Equal(t, "invalid datasource settings: workgroupName: a workgroup is required", res.Message)
+	assert.JSONEq(t, `{"errors":[{"field":"workgroupName","message":"a workgroup is required"}]}`, string(res.JSONDetails))
+}
+
+func TestCheckHealth_report(t *testing.T) {
+	ds := &AsyncDatasource{redshift: &fake.RedshiftFakeDatasource{RHealth: &models.HealthReport{Steps: []models.HealthStep{
+		{Name: "credentials", Status: models.HealthStepOK},
+		{Name: "query", Status: models.HealthStepError, Message: "permission denied for schema private"},
+	}}}}
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+			JSONData: json.RawMessage(`{"clusterIdentifier":"prod","dbUser":"grafana","database":"dev"}`),
+		}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusError, res.Status)
+	assert.Equal(t, "query check failed: permission denied for schema private", res.Message)
+	assert.Contains(t, string(res.JSONDetails), `"name":"credentials","status":"ok"`)
+}
+
+func TestCheckHealth_ignoredTeams(t *testing.T) {
+	ds := &AsyncDatasource{redshift: &fake.RedshiftFakeDatasource{RHealth: &models.HealthReport{Steps: []models.HealthStep{
+		{Name: "query", Status: models.HealthStepOK},
+	}}}}
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+			JSONData: json.RawMessage(`{"clusterIdentifier":"prod","dbUser":"grafana","database":"dev",
+				"identityMappings":[{"users":["alice"],"teams":["analysts"],"dbUser":"analyst"}]}`),
+		}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusOk, res.Status)
+	assert.Contains(t, res.Message, "the teams of identity mapping 1 are ignored")
+	assert.Contains(t, string(res.JSONDetails), `"warnings":[{"field":"identityMappings"`)
+}
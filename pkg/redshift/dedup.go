@@ -0,0 +1,134 @@
+package redshift
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"golang.org/x/sync/singleflight"
+)
+
+type queryDataFunc func(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error)
+
+// queryGroup coalesces identical queries running at the same time, e.g. the same dashboard opened
+// by several users or alert rules sharing a query, so that they run once and share the frames
+type queryGroup struct {
+	group singleflight.Group
+}
+
+// queryData runs each query of the request through the group, in parallel
+func (g *queryGroup) queryData(ctx context.Context, req *backend.QueryDataRequest, fn queryDataFunc) *backend.QueryDataResponse {
+	res := backend.NewQueryDataResponse()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, q := range req.Queries {
+		wg.Add(1)
+		go func(q backend.DataQuery) {
+			defer wg.Done()
+			r := g.query(ctx, req, q, fn)
+			mu.Lock()
+			res.Responses[q.RefID] = r
+			mu.Unlock()
+		}(q)
+	}
+	wg.Wait()
+	return res
+}
+
+// query runs the query, or waits for an identical query that is already running
+func (g *queryGroup) query(ctx context.Context, req *backend.QueryDataRequest, q backend.DataQuery, fn queryDataFunc) backend.DataResponse {
+	key, err := queryKey(req.PluginContext, q)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	single := *req
+	single.Queries = []backend.DataQuery{q}
+	// the query keeps running for the callers that share it even if the first one goes away
+	ch := g.group.DoChan(key, func() (interface{}, error) {
+		res, err := fn(context.WithoutCancel(ctx), &single)
+		if err != nil {
+			return nil, err
+		}
+		return res.Responses[q.RefID], nil
+	})
+	select {
+	case <-ctx.Done():
+		return backend.ErrorResponseWithErrorSource(backend.DownstreamError(ctx.Err()))
+	case r := <-ch:
+		if r.Err != nil {
+			return backend.ErrorResponseWithErrorSource(r.Err)
+		}
+		return copyResponse(r.Val.(backend.DataResponse), q.RefID)
+	}
+}
+
+// queryKey identifies a query by its datasource, time range and model, ignoring its refId
+func queryKey(pCtx backend.PluginContext, q backend.DataQuery) (string, error) {
+	fingerprint, err := queryFingerprint(pCtx, q)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%d/%d", fingerprint, q.TimeRange.From.UnixNano(), q.TimeRange.To.UnixNano()), nil
+}
+
+// queryFingerprint identifies a query by its datasource, identity and model, ignoring its refId and time range
+func queryFingerprint(pCtx backend.PluginContext, q backend.DataQuery) (string, error) {
+	model := map[string]interface{}{}
+	if err := json.Unmarshal(q.JSON, &model); err != nil {
+		return "", fmt.Errorf("invalid query model: %w", err)
+	}
+	delete(model, "refId")
+	b, err := json.Marshal(model)
+	if err != nil {
+		return "", err
+	}
+	var datasource string
+	if pCtx.DataSourceInstanceSettings != nil {
+		datasource = fmt.Sprintf("%d/%s", pCtx.DataSourceInstanceSettings.ID, pCtx.DataSourceInstanceSettings.Updated)
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s\n%s\n%d\n%s", datasource, queryIdentity(pCtx), q.QueryType, q.Interval, q.MaxDataPoints, b)))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// queryIdentity returns the database identity the user of the request is mapped to, or the user itself
+// with identity propagation, so that users with different identities do not share results
+func queryIdentity(pCtx backend.PluginContext) string {
+	settings, ok := datasourceSettings(pCtx)
+	if !ok {
+		return ""
+	}
+	if settings.IdentityPropagation != "" && pCtx.User != nil {
+		return "user:" + pCtx.User.Login
+	}
+	mapping, err := settings.UserIdentity(pCtx.User)
+	switch {
+	case err != nil && pCtx.User != nil:
+		// the query fails, but not for every user
+		return "unmapped:" + pCtx.User.Login
+	case mapping != nil:
+		return mapping.DBUser + "/" + mapping.SecretARN
+	}
+	return ""
+}
+
+// copyResponse returns a response for a caller sharing the query. Frames are shallow copies so that
+// each caller can set its refId and metadata without affecting the others.
+func copyResponse(r backend.DataResponse, refID string) backend.DataResponse {
+	res := r
+	res.Frames = make(data.Frames, len(r.Frames))
+	for i, frame := range r.Frames {
+		f := *frame
+		f.RefID = refID
+		if frame.Meta != nil {
+			meta := *frame.Meta
+			f.Meta = &meta
+		}
+		res.Frames[i] = &f
+	}
+	return res
+}
@@ -0,0 +1,83 @@
+package redshift
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_queryGroup(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func(_ context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		calls.Add(1)
+		<-release
+		q := req.Queries[0]
+		res := backend.NewQueryDataResponse()
+		res.Responses[q.RefID] = backend.DataResponse{Frames: data.Frames{data.NewFrame("").SetMeta(&data.FrameMeta{})}}
+		return res, nil
+	}
+	timeRange := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+	request := func(refID, sql string) *backend.QueryDataRequest {
+		model, _ := json.Marshal(map[string]string{"refId": refID, "rawSQL": sql})
+		return &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{ID: 1}},
+			Queries:       []backend.DataQuery{{RefID: refID, JSON: model, TimeRange: timeRange}},
+		}
+	}
+
+	g := &queryGroup{}
+	requests := []*backend.QueryDataRequest{request("A", "select 1"), request("B", "select 1"), request("A", "select 2")}
+	responses := make([]*backend.QueryDataResponse, len(requests))
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = g.queryData(context.Background(), req, fn)
+		}()
+	}
+	require.Eventually(t, func() bool { return calls.Load() == 2 }, time.Second, 10*time.Millisecond)
+	// give the duplicate query time to join
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Equal(t, "A", responses[0].Responses["A"].Frames[0].RefID)
+	assert.Equal(t, "B", responses[1].Responses["B"].Frames[0].RefID)
+	assert.NotSame(t, responses[0].Responses["A"].Frames[0].Meta, responses[1].Responses["B"].Frames[0].Meta)
+}
+
+func Test_queryFingerprint_identity(t *testing.T) {
+	pCtx := func(login string) backend.PluginContext {
+		return backend.PluginContext{
+			User: &backend.User{Login: login},
+			DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+				ID:       1,
+				JSONData: []byte(`{"identityMappings":[{"users":["alice"],"dbUser":"analyst"},{"users":["bob"],"dbUser":"finance"}]}`),
+			},
+		}
+	}
+	q := backend.DataQuery{RefID: "A", JSON: []byte(`{"rawSQL":"select 1"}`)}
+	alice, err := queryFingerprint(pCtx("alice"), q)
+	require.NoError(t, err)
+	bob, err := queryFingerprint(pCtx("bob"), q)
+	require.NoError(t, err)
+	carol, err := queryFingerprint(pCtx("carol"), q)
+	require.NoError(t, err)
+	dave, err := queryFingerprint(pCtx("dave"), q)
+	require.NoError(t, err)
+	assert.NotEqual(t, alice, bob)
+	assert.NotEqual(t, alice, carol)
+	// unmapped users share the identity of the datasource
+	assert.Equal(t, carol, dave)
+}
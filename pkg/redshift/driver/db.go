@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql/driver"
 	"fmt"
+	"sync"
+	"time"
 
 	redshiftdatatypes "github.com/aws/aws-sdk-go-v2/service/redshiftdata/types"
 	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
@@ -14,15 +16,33 @@ import (
 
 var _ awsds.AsyncDB = &db{}
 
+const (
+	// stopTimeout bounds the time spent canceling a query that exceeded the max execution time
+	stopTimeout = 30 * time.Second
+	// timedOutRetention is how long a query canceled for exceeding the max execution time is remembered
+	// for its caller, which stops polling it when the caller went away
+	timedOutRetention = 10 * time.Minute
+)
+
 // Implements AsyncDB
 type db struct {
 	api    *api.API
 	closed bool
+
+	mu sync.Mutex
+	// queries canceled for exceeding the max execution time
+	timedOut map[string]timedOutQuery
+}
+
+type timedOutQuery struct {
+	timeout time.Duration
+	expires time.Time
 }
 
 func newDB(api *api.API) *db {
 	return &db{
-		api: api,
+		api:      api,
+		timedOut: map[string]timedOutQuery{},
 	}
 }
 
@@ -31,14 +51,74 @@ func (d *db) StartQuery(ctx context.Context, query string, _ ...interface{}) (st
 	if err != nil {
 		return "", err
 	}
+	d.enforceTimeout(ctx, output.ID)
 	return output.ID, nil
 }
 
+// enforceTimeout cancels the query if it is still running once the max execution time has elapsed,
+// so that queries abandoned by their caller do not keep running in Redshift. The time spent queued
+// does not count, the timer starts once the query is submitted. The context of the query is kept,
+// without its cancellation, for the credentials of the user with identity propagation.
+func (d *db) enforceTimeout(ctx context.Context, queryID string) {
+	timeout := d.api.MaxExecutionTime()
+	if timeout <= 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	d.api.WhenSubmitted(queryID, func() {
+		time.AfterFunc(timeout, func() { d.cancelTimedOut(ctx, queryID, timeout) })
+	})
+}
+
+// cancelTimedOut cancels a query that exceeded the max execution time, unless it already finished
+func (d *db) cancelTimedOut(ctx context.Context, queryID string, timeout time.Duration) {
+	status, err := d.api.Status(ctx, &sqlAPI.ExecuteQueryOutput{ID: queryID})
+	if err != nil || status.Finished {
+		return
+	}
+	d.markTimedOut(queryID, timeout)
+	backend.Logger.Debug("canceling query that exceeded the max execution time", "queryID", queryID, "timeout", timeout)
+	stopCtx, cancel := context.WithTimeout(ctx, stopTimeout)
+	defer cancel()
+	if err := d.api.CancelQuery(stopCtx, nil, queryID); err != nil {
+		backend.Logger.Warn("failed to cancel query that exceeded the max execution time", "queryID", queryID, "error", err)
+	}
+}
+
+// markTimedOut remembers that the query was canceled for exceeding the max execution time, forgetting
+// the queries that were canceled a while ago
+func (d *db) markTimedOut(queryID string, timeout time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for id, q := range d.timedOut {
+		if now.After(q.expires) {
+			delete(d.timedOut, id)
+		}
+	}
+	d.timedOut[queryID] = timedOutQuery{timeout: timeout, expires: now.Add(timedOutRetention)}
+}
+
+// takeTimedOut returns the max execution time the query exceeded, if it was canceled for it
+func (d *db) takeTimedOut(queryID string) (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	q, ok := d.timedOut[queryID]
+	if !ok {
+		return 0, false
+	}
+	delete(d.timedOut, queryID)
+	return q.timeout, time.Now().Before(q.expires)
+}
+
 func (d *db) GetQueryID(ctx context.Context, query string, args ...interface{}) (bool, string, error) {
 	return d.api.GetQueryID(ctx, query, args)
 }
 
 func (d *db) QueryStatus(ctx context.Context, queryID string) (awsds.QueryStatus, error) {
+	if timeout, ok := d.takeTimedOut(queryID); ok {
+		return awsds.QueryCanceled, backend.DownstreamError(fmt.Errorf("query exceeded the maximum execution time of %s and was canceled", timeout))
+	}
 	status, err := d.api.Status(ctx, &sqlAPI.ExecuteQueryOutput{ID: queryID})
 	if err != nil {
 		return awsds.QueryUnknown, err
@@ -66,7 +146,10 @@ func (d *db) CancelQuery(_ context.Context, queryID string) error {
 }
 
 func (d *db) GetRows(ctx context.Context, queryID string) (driver.Rows, error) {
-	return newRows(ctx, d.api.DataClient, queryID)
+	if err := d.api.CheckOwner(ctx, queryID); err != nil {
+		return nil, err
+	}
+	return newRows(ctx, d.api.DataClient, d.api.StatementID(queryID))
 }
 
 func (d *db) Ping(ctx context.Context) error {
@@ -0,0 +1,25 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimedOutQueries(t *testing.T) {
+	d := newDB(nil)
+	d.markTimedOut("foo", time.Minute)
+	timeout, ok := d.takeTimedOut("foo")
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, timeout)
+	_, ok = d.takeTimedOut("foo")
+	assert.False(t, ok)
+
+	// queries whose caller stopped polling are forgotten once they expire
+	d.markTimedOut("bar", time.Minute)
+	d.timedOut["bar"] = timedOutQuery{timeout: time.Minute, expires: time.Now().Add(-time.Second)}
+	d.markTimedOut("baz", time.Minute)
+	assert.NotContains(t, d.timedOut, "bar")
+	assert.Contains(t, d.timedOut, "baz")
+}
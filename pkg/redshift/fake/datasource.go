@@ -13,10 +13,15 @@ import (
 )
 
 type RedshiftFakeDatasource struct {
-	SecretList  []models.ManagedSecret
-	RSecret     models.RedshiftSecret
-	RClusters   []models.RedshiftCluster
-	RWorkgroups []models.RedshiftWorkgroup
+	SecretList   []models.ManagedSecret
+	RSecret      models.RedshiftSecret
+	RClusters    []models.RedshiftCluster
+	RWorkgroups  []models.RedshiftWorkgroup
+	RHistory     []models.RedshiftStatement
+	RRunning     []models.RedshiftStatement
+	RPlan        *models.QueryPlan
+	RHealth      *models.HealthReport
+	RPermissions *models.PermissionReport
 }
 
 func (s *RedshiftFakeDatasource) Settings(_ context.Context, _ backend.DataSourceInstanceSettings) sqlds.DriverSettings {
@@ -76,3 +81,30 @@ func (s *RedshiftFakeDatasource) Clusters(ctx context.Context, options sqlds.Opt
 func (s *RedshiftFakeDatasource) Workgroups(ctx context.Context, options sqlds.Options) ([]models.RedshiftWorkgroup, error) {
 	return s.RWorkgroups, nil
 }
+func (s *RedshiftFakeDatasource) History(ctx context.Context, options sqlds.Options) ([]models.RedshiftStatement, error) {
+	return s.RHistory, nil
+}
+
+func (s *RedshiftFakeDatasource) Running(ctx context.Context, options sqlds.Options) ([]models.RedshiftStatement, error) {
+	return s.RRunning, nil
+}
+
+func (s *RedshiftFakeDatasource) CancelStatements(ctx context.Context, options sqlds.Options) (*models.CancelResult, error) {
+	res := &models.CancelResult{Canceled: []string{}}
+	for _, statement := range s.RRunning {
+		res.Canceled = append(res.Canceled, statement.ID)
+	}
+	return res, nil
+}
+
+func (s *RedshiftFakeDatasource) Explain(ctx context.Context, query *sqlutil.Query) (*models.QueryPlan, error) {
+	return s.RPlan, nil
+}
+
+func (s *RedshiftFakeDatasource) CheckHealth(_ context.Context) (*models.HealthReport, error) {
+	return s.RHealth, nil
+}
+
+func (s *RedshiftFakeDatasource) Permissions(_ context.Context, _ sqlds.Options) (*models.PermissionReport, error) {
+	return s.RPermissions, nil
+}
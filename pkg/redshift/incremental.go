@@ -0,0 +1,233 @@
+package redshift
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	defaultIncrementalCacheTTL = 15 * time.Minute
+	maxIncrementalCacheEntries = 500
+)
+
+type incrementalEntry struct {
+	from    time.Time
+	to      time.Time
+	frames  data.Frames
+	expires time.Time
+}
+
+// aggregatedSQL matches queries that aggregate rows, e.g. into time buckets. The value of a bucket depends on
+// the part of the bucket within the queried range, so their result cannot be merged from parts of the range.
+var aggregatedSQL = regexp.MustCompile(`(?i)\bgroup\s+by\b|\bdistinct\b|\bover\s*\(|\$__(timeGroup|unixEpochGroup)\b|\b(count|sum|avg|min|max|median|listagg|stddev|stddev_samp|stddev_pop|variance|var_samp|var_pop|percentile_cont|percentile_disc|approximate)\s*\(`)
+
+// limitedSQL matches queries that sort rows in descending order or keep only some of them. The rows of
+// the cached range and of the delta cannot be put together in order, or would not be the same rows.
+var limitedSQL = regexp.MustCompile(`(?i)\b(desc|limit|offset)\b|\btop\s+\d|\bfetch\s+(first|next)\b`)
+
+// errIncrementalCacheStale is returned when the fields of the result of a cached query changed
+var errIncrementalCacheStale = errors.New("the fields of the query result changed since it was cached, run it again")
+
+// incrementalCache keeps the frames of time series queries so that, when a query runs again over a
+// range overlapping the previous one (e.g. an auto-refreshing "last 24 hours" dashboard), only the part
+// of the range that is not covered yet is queried. The last interval of the cached range is queried
+// again, since its data may have been incomplete. Only queries returning rows as they are stored, in
+// ascending order, are cached: aggregated or limited queries always run over their whole range. With
+// the async query flow, the delta is polled by the requests polling the cached query.
+type incrementalCache struct {
+	mu      sync.Mutex
+	entries map[string]incrementalEntry
+	deltas  *partQueries
+}
+
+func newIncrementalCache() *incrementalCache {
+	return &incrementalCache{entries: map[string]incrementalEntry{}, deltas: newPartQueries("incremental-")}
+}
+
+// queryData wraps fn, which runs requests made of a single query, with the cache
+func (c *incrementalCache) queryData(fn queryDataFunc) queryDataFunc {
+	return func(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		if res, ok, err := c.deltas.poll(ctx, fn, req); ok {
+			return res, err
+		}
+		ttl, enabled := incrementalCacheTTL(req.PluginContext)
+		q := req.Queries[0]
+		if !enabled || isPoll(q) || !filtersTimeRange(q) || aggregates(q) || limits(q) {
+			return fn(ctx, req)
+		}
+		key, err := queryFingerprint(req.PluginContext, q)
+		if err != nil {
+			return fn(ctx, req)
+		}
+
+		delta := q
+		entry, cached := c.get(key)
+		if cached && entry.covers(q.TimeRange) {
+			delta.TimeRange.From = entry.to.Add(-q.Interval)
+			if delta.TimeRange.From.Before(q.TimeRange.From) {
+				delta.TimeRange.From = q.TimeRange.From
+			}
+		} else {
+			cached = false
+		}
+
+		res, err := c.deltas.run(ctx, fn, req, []backend.DataQuery{delta}, func(responses []backend.DataResponse) backend.DataResponse {
+			r := responses[0]
+			frames := r.Frames
+			if cached {
+				merged, ok := mergeFrames(entry.frames, frames, q.TimeRange.From, delta.TimeRange.From)
+				if !ok {
+					c.delete(key)
+					return backend.ErrorResponseWithErrorSource(backend.DownstreamError(errIncrementalCacheStale))
+				}
+				frames = merged
+			}
+			if hasTimeFields(frames) {
+				c.set(key, incrementalEntry{from: q.TimeRange.From, to: q.TimeRange.To, frames: frames, expires: time.Now().Add(ttl)})
+			}
+			r.Frames = frames
+			return r
+		})
+		if err == nil && !isAsyncFlow(req) && errors.Is(res.Responses[q.RefID].Error, errIncrementalCacheStale) {
+			// with the sync query flow, the whole range is queried again right away
+			return c.queryData(fn)(ctx, req)
+		}
+		return res, err
+	}
+}
+
+func (c *incrementalCache) get(key string) (incrementalEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return incrementalEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *incrementalCache) set(key string, entry incrementalEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxIncrementalCacheEntries {
+		return
+	}
+	c.entries[key] = entry
+}
+
+func (c *incrementalCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// covers returns true if the range starts within the cached range and ends after it
+func (e incrementalEntry) covers(timeRange backend.TimeRange) bool {
+	return !timeRange.From.Before(e.from) && !timeRange.From.After(e.to) && !timeRange.To.Before(e.to)
+}
+
+// aggregates returns true if the query aggregates rows, or if its SQL cannot be read
+func aggregates(q backend.DataQuery) bool {
+	query, err := awsds.GetQuery(q)
+	return err != nil || aggregatedSQL.MatchString(query.RawSQL)
+}
+
+// limits returns true if the query sorts rows in descending order or keeps only some of them, or if its
+// SQL cannot be read
+func limits(q backend.DataQuery) bool {
+	query, err := awsds.GetQuery(q)
+	return err != nil || limitedSQL.MatchString(query.RawSQL)
+}
+
+// incrementalCacheTTL returns how long frames are cached for the datasource, and whether the cache is enabled
+func incrementalCacheTTL(pCtx backend.PluginContext) (time.Duration, bool) {
+	settings, ok := datasourceSettings(pCtx)
+	if !ok || !settings.UseIncrementalCache {
+		return 0, false
+	}
+	if settings.IncrementalCacheTTLSeconds > 0 {
+		return time.Duration(settings.IncrementalCacheTTLSeconds) * time.Second, true
+	}
+	return defaultIncrementalCacheTTL, true
+}
+
+func hasTimeFields(frames data.Frames) bool {
+	for _, frame := range frames {
+		if len(frame.TypeIndices(data.FieldTypeTime, data.FieldTypeNullableTime)) == 0 {
+			return false
+		}
+	}
+	return len(frames) > 0
+}
+
+// mergeFrames returns the cached rows from the start of the range until the delta followed by the
+// rows of the delta. It returns false if the frames of the delta do not have the same fields.
+func mergeFrames(cached, delta data.Frames, from, deltaFrom time.Time) (data.Frames, bool) {
+	if len(cached) != len(delta) {
+		return nil, false
+	}
+	res := make(data.Frames, len(delta))
+	for i := range delta {
+		if !sameFields(cached[i], delta[i]) {
+			return nil, false
+		}
+		timeIndices := cached[i].TypeIndices(data.FieldTypeTime, data.FieldTypeNullableTime)
+		if len(timeIndices) == 0 {
+			return nil, false
+		}
+		timeField := cached[i].Fields[timeIndices[0]]
+		merged := delta[i].EmptyCopy()
+		for row := 0; row < timeField.Len(); row++ {
+			t, ok := timeAt(timeField, row)
+			if ok && !t.Before(from) && t.Before(deltaFrom) {
+				merged.AppendRow(cached[i].RowCopy(row)...)
+			}
+		}
+		rows, err := delta[i].RowLen()
+		if err != nil {
+			return nil, false
+		}
+		for row := 0; row < rows; row++ {
+			merged.AppendRow(delta[i].RowCopy(row)...)
+		}
+		res[i] = merged
+	}
+	return res, true
+}
+
+func sameFields(a, b *data.Frame) bool {
+	if len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i := range a.Fields {
+		if a.Fields[i].Name != b.Fields[i].Name || a.Fields[i].Type() != b.Fields[i].Type() {
+			return false
+		}
+	}
+	return true
+}
+
+func timeAt(field *data.Field, row int) (time.Time, bool) {
+	switch v := field.At(row).(type) {
+	case time.Time:
+		return v, true
+	case *time.Time:
+		if v != nil {
+			return *v, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,257 @@
+package redshift
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_incrementalCache(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// one row per minute of the queried range
+	var queried []backend.TimeRange
+	fn := func(_ context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		q := req.Queries[0]
+		queried = append(queried, q.TimeRange)
+		frame := data.NewFrame("", data.NewField("time", nil, []time.Time{}), data.NewField("value", nil, []int64{}))
+		for ts := q.TimeRange.From; !ts.After(q.TimeRange.To); ts = ts.Add(time.Minute) {
+			frame.AppendRow(ts, ts.Unix())
+		}
+		res := backend.NewQueryDataResponse()
+		res.Responses[q.RefID] = backend.DataResponse{Frames: data.Frames{frame}}
+		return res, nil
+	}
+	model, _ := json.Marshal(map[string]string{"refId": "A", "rawSQL": "SELECT time, value FROM t WHERE $__timeFilter(time)"})
+	request := func(from, to time.Time) *backend.QueryDataRequest {
+		return &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+				ID:       1,
+				JSONData: []byte(`{"useIncrementalCache":true}`),
+			}},
+			Queries: []backend.DataQuery{{RefID: "A", JSON: model, Interval: time.Minute, TimeRange: backend.TimeRange{From: from, To: to}}},
+		}
+	}
+
+	c := newIncrementalCache()
+	_, err := c.queryData(fn)(context.Background(), request(start, start.Add(time.Hour)))
+	require.NoError(t, err)
+
+	res, err := c.queryData(fn)(context.Background(), request(start.Add(5*time.Minute), start.Add(65*time.Minute)))
+	require.NoError(t, err)
+	require.Len(t, queried, 2)
+	// only the last cached minute and the new ones are queried
+	assert.Equal(t, start.Add(59*time.Minute), queried[1].From)
+
+	frame := res.Responses["A"].Frames[0]
+	require.Equal(t, 61, frame.Rows())
+	for i := 0; i < frame.Rows(); i++ {
+		assert.Equal(t, start.Add(time.Duration(5+i)*time.Minute), frame.At(0, i))
+	}
+}
+
+func Test_incrementalCache_disabled(t *testing.T) {
+	calls := 0
+	fn := func(_ context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		calls++
+		assert.Equal(t, time.Unix(0, 0), req.Queries[0].TimeRange.From)
+		return backend.NewQueryDataResponse(), nil
+	}
+	model, _ := json.Marshal(map[string]string{"refId": "A", "rawSQL": "SELECT 1 WHERE $__timeFilter(time)"})
+	req := &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{ID: 1}},
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: model, TimeRange: backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)}}},
+	}
+	c := newIncrementalCache()
+	for i := 0; i < 2; i++ {
+		_, err := c.queryData(fn)(context.Background(), req)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, calls)
+	assert.Empty(t, c.entries)
+}
+
+func Test_incrementalCache_aggregated(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// the count of events per 10 minutes bucket, with one event per minute of the queried range
+	var queried []backend.TimeRange
+	fn := func(_ context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		q := req.Queries[0]
+		queried = append(queried, q.TimeRange)
+		counts := map[time.Time]int64{}
+		buckets := []time.Time{}
+		for ts := q.TimeRange.From; !ts.After(q.TimeRange.To); ts = ts.Add(time.Minute) {
+			bucket := ts.Truncate(10 * time.Minute)
+			if _, ok := counts[bucket]; !ok {
+				buckets = append(buckets, bucket)
+			}
+			counts[bucket]++
+		}
+		frame := data.NewFrame("", data.NewField("time", nil, []time.Time{}), data.NewField("count", nil, []int64{}))
+		for _, bucket := range buckets {
+			frame.AppendRow(bucket, counts[bucket])
+		}
+		res := backend.NewQueryDataResponse()
+		res.Responses[q.RefID] = backend.DataResponse{Frames: data.Frames{frame}}
+		return res, nil
+	}
+	model, _ := json.Marshal(map[string]string{"refId": "A", "rawSQL": "SELECT date_trunc('minute', time) AS time, count(*) FROM t WHERE $__timeFilter(time) GROUP BY 1 ORDER BY 1"})
+	request := func(from, to time.Time) *backend.QueryDataRequest {
+		return &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+				ID:       1,
+				JSONData: []byte(`{"useIncrementalCache":true}`),
+			}},
+			Queries: []backend.DataQuery{{RefID: "A", JSON: model, Interval: time.Minute, TimeRange: backend.TimeRange{From: from, To: to}}},
+		}
+	}
+
+	c := newIncrementalCache()
+	_, err := c.queryData(fn)(context.Background(), request(start, start.Add(time.Hour)))
+	require.NoError(t, err)
+	res, err := c.queryData(fn)(context.Background(), request(start.Add(5*time.Minute), start.Add(65*time.Minute)))
+	require.NoError(t, err)
+
+	// the whole range is queried again, the buckets match those of a single query
+	require.Len(t, queried, 2)
+	assert.Equal(t, start.Add(5*time.Minute), queried[1].From)
+	expected, err := fn(context.Background(), request(start.Add(5*time.Minute), start.Add(65*time.Minute)))
+	require.NoError(t, err)
+	assert.Equal(t, expected.Responses["A"].Frames, res.Responses["A"].Frames)
+	assert.Empty(t, c.entries)
+}
+
+func Test_aggregates(t *testing.T) {
+	for sql, expected := range map[string]bool{
+		"SELECT time, value FROM t WHERE $__timeFilter(time)":                                     false,
+		"SELECT time, max_value FROM t WHERE $__timeFilter(time)":                                 false,
+		"SELECT $__timeGroup(time, '5m'), avg(value) FROM t WHERE $__timeFilter(time) GROUP BY 1": true,
+		"SELECT time, sum(value) OVER (ORDER BY time) FROM t WHERE $__timeFilter(time)":           true,
+		"SELECT DISTINCT time FROM t WHERE $__timeFilter(time)":                                   true,
+		"select time, count(*) from t where $__timeFilter(time) group by time":                    true,
+	} {
+		model, _ := json.Marshal(map[string]string{"rawSQL": sql})
+		assert.Equal(t, expected, aggregates(backend.DataQuery{JSON: model}), sql)
+	}
+}
+
+// asyncQueryData runs requests like the async query flow, with a statement per started query. The frames
+// of a statement hold one row per minute of its range, returned once it has been polled twice.
+func asyncQueryData(queried *[]backend.TimeRange) queryDataFunc {
+	var mu sync.Mutex
+	polls := map[string]int{}
+	ranges := map[string]backend.TimeRange{}
+	return func(_ context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		q := req.Queries[0]
+		model := struct {
+			QueryID string `json:"queryID"`
+		}{}
+		if err := json.Unmarshal(q.JSON, &model); err != nil {
+			return nil, err
+		}
+		res := backend.NewQueryDataResponse()
+		if model.QueryID == "" {
+			*queried = append(*queried, q.TimeRange)
+			id := fmt.Sprintf("statement-%d", len(ranges))
+			ranges[id] = q.TimeRange
+			res.Responses[q.RefID] = statusResponse(q, id, "started")
+			return res, nil
+		}
+		polls[model.QueryID]++
+		if polls[model.QueryID] < 2 {
+			res.Responses[q.RefID] = statusResponse(q, model.QueryID, "running")
+			return res, nil
+		}
+		timeRange := ranges[model.QueryID]
+		frame := data.NewFrame("", data.NewField("time", nil, []time.Time{}), data.NewField("value", nil, []int64{}))
+		for ts := timeRange.From; !ts.After(timeRange.To); ts = ts.Add(time.Minute) {
+			frame.AppendRow(ts, ts.Unix())
+		}
+		frame.Meta = &data.FrameMeta{Custom: queryMeta{QueryID: model.QueryID, Status: "finished"}}
+		res.Responses[q.RefID] = backend.DataResponse{Frames: data.Frames{frame}}
+		return res, nil
+	}
+}
+
+// runAsync starts the query of the request and polls it until it finishes
+func runAsync(t *testing.T, fn queryDataFunc, req *backend.QueryDataRequest) backend.DataResponse {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		res, err := fn(context.Background(), req)
+		require.NoError(t, err)
+		r := res.Responses[req.Queries[0].RefID]
+		require.NoError(t, r.Error)
+		meta, ok := responseMeta(r)
+		require.True(t, ok)
+		if meta.Status == "finished" {
+			return r
+		}
+		poll, err := withQueryID(req.Queries[0], meta.QueryID)
+		require.NoError(t, err)
+		req = &backend.QueryDataRequest{PluginContext: req.PluginContext, Queries: []backend.DataQuery{poll}}
+	}
+	require.FailNow(t, "the query did not finish")
+	return backend.DataResponse{}
+}
+
+func Test_incrementalCache_async(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var queried []backend.TimeRange
+	fn := asyncQueryData(&queried)
+	model, _ := json.Marshal(map[string]interface{}{
+		"refId":  "A",
+		"rawSQL": "SELECT time, value FROM t WHERE $__timeFilter(time)",
+		"meta":   map[string]string{"queryFlow": "async"},
+	})
+	request := func(from, to time.Time) *backend.QueryDataRequest {
+		return &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+				ID:       1,
+				JSONData: []byte(`{"useIncrementalCache":true}`),
+			}},
+			Queries: []backend.DataQuery{{RefID: "A", JSON: model, Interval: time.Minute, TimeRange: backend.TimeRange{From: from, To: to}}},
+		}
+	}
+
+	c := newIncrementalCache()
+	r := runAsync(t, c.queryData(fn), request(start, start.Add(time.Hour)))
+	assert.Equal(t, 61, r.Frames[0].Rows())
+
+	r = runAsync(t, c.queryData(fn), request(start.Add(5*time.Minute), start.Add(65*time.Minute)))
+	require.Len(t, queried, 2)
+	// only the last cached minute and the new ones are queried
+	assert.Equal(t, start.Add(59*time.Minute), queried[1].From)
+
+	frame := r.Frames[0]
+	require.Equal(t, 61, frame.Rows())
+	for i := 0; i < frame.Rows(); i++ {
+		assert.Equal(t, start.Add(time.Duration(5+i)*time.Minute), frame.At(0, i))
+	}
+	meta, _ := responseMeta(r)
+	assert.True(t, strings.HasPrefix(meta.QueryID, "incremental-"))
+	assert.Empty(t, c.deltas.queries)
+}
+
+func Test_limits(t *testing.T) {
+	for sql, expected := range map[string]bool{
+		"SELECT time, value FROM t WHERE $__timeFilter(time) ORDER BY time":                   false,
+		"SELECT time, description FROM t WHERE $__timeFilter(time)":                           false,
+		"SELECT time, value FROM t WHERE $__timeFilter(time) ORDER BY time DESC":              true,
+		"SELECT time, value FROM t WHERE $__timeFilter(time) ORDER BY time LIMIT 100":         true,
+		"SELECT TOP 10 time, value FROM t WHERE $__timeFilter(time)":                          true,
+		"SELECT time, value FROM t WHERE $__timeFilter(time) ORDER BY time FETCH FIRST 1 ROW": true,
+	} {
+		model, _ := json.Marshal(map[string]string{"rawSQL": sql})
+		assert.Equal(t, expected, limits(backend.DataQuery{JSON: model}), sql)
+	}
+}
@@ -0,0 +1,48 @@
+package models
+
+import "fmt"
+
+// Statuses of the steps of a health check
+const (
+	HealthStepOK      = "ok"
+	HealthStepError   = "error"
+	HealthStepSkipped = "skipped"
+	// HealthStepWarning is the status of steps that could not check what they check, e.g. for lack of
+	// permissions the datasource does not need to run queries. They do not fail the health check.
+	HealthStepWarning = "warning"
+)
+
+// HealthStep is a check run by the health check, e.g. that the target is available
+type HealthStep struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// HealthReport lists the steps of a health check in the order they ran
+type HealthReport struct {
+	Steps []HealthStep `json:"steps"`
+	// Warnings are the settings the datasource ignores, see RedshiftDataSourceSettings.Warnings
+	Warnings []FieldError `json:"warnings,omitempty"`
+}
+
+// Failed returns true if the step ran and failed
+func (r *HealthReport) Failed(name string) bool {
+	for _, step := range r.Steps {
+		if step.Name == name {
+			return step.Status == HealthStepError
+		}
+	}
+	return false
+}
+
+// Err returns the error of the first failed step, or nil if none failed
+func (r *HealthReport) Err() error {
+	for _, step := range r.Steps {
+		if step.Status == HealthStepError {
+			return fmt.Errorf("%s check failed: %s", step.Name, step.Message)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// IdentityMapping maps Grafana users to the database identity their queries run with. Grafana does not
+// pass the teams of a user to plugins, so a team is mapped by listing the logins or emails of its members.
+type IdentityMapping struct {
+	// Users are Grafana logins or emails
+	Users     []string `json:"users"`
+	DBUser    string   `json:"dbUser"`
+	SecretARN string   `json:"secretARN"`
+}
+
+const (
+	// IdentityPropagationWebIdentity assumes a role with the OIDC token of the user
+	IdentityPropagationWebIdentity = "webIdentity"
+	// IdentityPropagationIdentityCenter exchanges the token of the user for an IAM Identity Center
+	// identity context and assumes a role with it (trusted identity propagation)
+	IdentityPropagationIdentityCenter = "identityCenter"
+)
+
+var ErrUnmappedUser = errors.New("no database identity is mapped to the Grafana user")
+
+// UserIdentity returns the mapping of the user, or nil if the queries of the user run with the identity
+// of the datasource. Requests without a user, e.g. from alerting, are unmapped.
+func (s *RedshiftDataSourceSettings) UserIdentity(user *backend.User) (*IdentityMapping, error) {
+	if user != nil {
+		for i, mapping := range s.IdentityMappings {
+			for _, u := range mapping.Users {
+				if u != "" && (strings.EqualFold(u, user.Login) || strings.EqualFold(u, user.Email)) {
+					return &s.IdentityMappings[i], nil
+				}
+			}
+		}
+	}
+	if !s.DenyUnmappedUsers {
+		return nil, nil
+	}
+	if user == nil {
+		return nil, ErrUnmappedUser
+	}
+	return nil, fmt.Errorf("%w %s", ErrUnmappedUser, user.Login)
+}
+
+// mappedTeams returns the numbers of the identity mappings that list teams in the JSON data of the datasource.
+// Teams cannot be resolved, the members of a team must be listed as users instead.
+func (s *RedshiftDataSourceSettings) mappedTeams() []int {
+	var data struct {
+		IdentityMappings []struct {
+			Teams []string `json:"teams"`
+		} `json:"identityMappings"`
+	}
+	if len(s.Config.JSONData) == 0 || json.Unmarshal(s.Config.JSONData, &data) != nil {
+		return nil
+	}
+	mappings := []int{}
+	for i, mapping := range data.IdentityMappings {
+		if len(mapping.Teams) > 0 {
+			mappings = append(mappings, i+1)
+		}
+	}
+	return mappings
+}
+
+// validateIdentityMappings checks that every mapping has users and a single identity that the targets accept
+func (s *RedshiftDataSourceSettings) validateIdentityMappings() error {
+	for i, mapping := range s.IdentityMappings {
+		switch {
+		case len(mapping.Users) == 0:
+			return fmt.Errorf("invalid identity mapping %d: no users", i+1)
+		case (mapping.DBUser == "") == (mapping.SecretARN == ""):
+			return fmt.Errorf("invalid identity mapping %d: set either a database user or a secret ARN", i+1)
+		case s.UseServerless && mapping.DBUser != "":
+			return fmt.Errorf("invalid identity mapping %d: serverless workgroups do not accept a database user, map users to a secret instead", i+1)
+		case mapping.DBUser != "" && s.hasFailoverWorkgroup():
+			return fmt.Errorf("invalid identity mapping %d: the failover workgroups do not accept a database user, map users to a secret instead", i+1)
+		}
+	}
+	return nil
+}
+
+// validateIdentityPropagation checks that propagation has what it needs. The identity of the user replaces
+// the database user, the managed secret and identity mappings, so they cannot be used with it.
+func (s *RedshiftDataSourceSettings) validateIdentityPropagation() error {
+	switch s.IdentityPropagation {
+	case "":
+		return nil
+	case IdentityPropagationWebIdentity, IdentityPropagationIdentityCenter:
+	default:
+		return fmt.Errorf("invalid identity propagation %q, expected %q or %q", s.IdentityPropagation, IdentityPropagationWebIdentity, IdentityPropagationIdentityCenter)
+	}
+	switch {
+	case s.IdentityRoleARN == "":
+		return fmt.Errorf("identity propagation requires a role ARN")
+	case s.IdentityPropagation == IdentityPropagationIdentityCenter && s.IdentityCenterApplicationARN == "":
+		return fmt.Errorf("identity propagation through IAM Identity Center requires an application ARN")
+	case s.UseManagedSecret:
+		return fmt.Errorf("identity propagation cannot be used with a managed secret")
+	case len(s.IdentityMappings) > 0:
+		return fmt.Errorf("identity propagation cannot be used with identity mappings")
+	}
+	return nil
+}
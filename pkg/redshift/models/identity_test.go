@@ -0,0 +1,92 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserIdentity(t *testing.T) {
+	s := &RedshiftDataSourceSettings{IdentityMappings: []IdentityMapping{
+		{Users: []string{"alice", "bob@example.com"}, DBUser: "analyst"},
+		{Users: []string{"carol"}, SecretARN: "arn:finance"},
+	}}
+	mapping, err := s.UserIdentity(&backend.User{Login: "bob", Email: "Bob@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "analyst", mapping.DBUser)
+	mapping, err = s.UserIdentity(&backend.User{Login: "carol"})
+	require.NoError(t, err)
+	assert.Equal(t, "arn:finance", mapping.SecretARN)
+
+	mapping, err = s.UserIdentity(&backend.User{Login: "dave"})
+	require.NoError(t, err)
+	assert.Nil(t, mapping)
+
+	s.DenyUnmappedUsers = true
+	_, err = s.UserIdentity(&backend.User{Login: "dave"})
+	assert.ErrorIs(t, err, ErrUnmappedUser)
+	_, err = s.UserIdentity(nil)
+	assert.ErrorIs(t, err, ErrUnmappedUser)
+}
+
+func TestValidateIdentityMappings(t *testing.T) {
+	tests := []struct {
+		description string
+		settings    RedshiftDataSourceSettings
+		err         string
+	}{
+		{
+			description: "valid",
+			settings:    RedshiftDataSourceSettings{IdentityMappings: []IdentityMapping{{Users: []string{"alice"}, DBUser: "analyst"}}},
+		},
+		{
+			description: "no users",
+			settings:    RedshiftDataSourceSettings{IdentityMappings: []IdentityMapping{{DBUser: "analyst"}}},
+			err:         "no users",
+		},
+		{
+			description: "both identities",
+			settings:    RedshiftDataSourceSettings{IdentityMappings: []IdentityMapping{{Users: []string{"alice"}, DBUser: "analyst", SecretARN: "arn"}}},
+			err:         "set either a database user or a secret ARN",
+		},
+		{
+			description: "database user on serverless",
+			settings:    RedshiftDataSourceSettings{UseServerless: true, IdentityMappings: []IdentityMapping{{Users: []string{"alice"}, DBUser: "analyst"}}},
+			err:         "serverless workgroups do not accept a database user",
+		},
+		{
+			description: "database user with a failover workgroup",
+			settings: RedshiftDataSourceSettings{
+				IdentityMappings: []IdentityMapping{{Users: []string{"alice"}, DBUser: "analyst"}},
+				FailoverTargets:  []Target{{WorkgroupName: "serverless", SecretARN: "arn"}},
+			},
+			err: "the failover workgroups do not accept a database user",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			err := tt.settings.validateIdentityMappings()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateIdentityPropagation(t *testing.T) {
+	s := &RedshiftDataSourceSettings{IdentityPropagation: IdentityPropagationWebIdentity, IdentityRoleARN: "arn:role"}
+	assert.NoError(t, s.validateIdentityPropagation())
+
+	s = &RedshiftDataSourceSettings{IdentityPropagation: IdentityPropagationIdentityCenter, IdentityRoleARN: "arn:role"}
+	assert.ErrorContains(t, s.validateIdentityPropagation(), "requires an application ARN")
+
+	s = &RedshiftDataSourceSettings{IdentityPropagation: IdentityPropagationWebIdentity, IdentityRoleARN: "arn:role", UseManagedSecret: true}
+	assert.ErrorContains(t, s.validateIdentityPropagation(), "cannot be used with a managed secret")
+
+	s = &RedshiftDataSourceSettings{IdentityPropagation: "saml"}
+	assert.ErrorContains(t, s.validateIdentityPropagation(), "invalid identity propagation")
+}
@@ -0,0 +1,21 @@
+package models
+
+// Results of probing an API action
+const (
+	PermissionAllowed    = "allowed"
+	PermissionDenied     = "denied"
+	PermissionUntestable = "untestable"
+)
+
+// ActionPermission is the result of probing an IAM action the datasource uses, e.g. "redshift-data:ExecuteStatement"
+type ActionPermission struct {
+	Action  string `json:"action"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// PermissionReport lists the actions probed and the names of those denied, to add to the IAM policy
+type PermissionReport struct {
+	Actions []ActionPermission `json:"actions"`
+	Missing []string           `json:"missing"`
+}
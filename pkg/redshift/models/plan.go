@@ -0,0 +1,125 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PlanNode is a step of a query plan
+type PlanNode struct {
+	ID          int         `json:"id"`
+	Operation   string      `json:"operation"`
+	StartupCost float64     `json:"startupCost"`
+	TotalCost   float64     `json:"totalCost"`
+	Rows        int64       `json:"rows"`
+	Width       int64       `json:"width"`
+	Details     []string    `json:"details,omitempty"`
+	Children    []*PlanNode `json:"children,omitempty"`
+}
+
+// QueryPlan is the plan Redshift returns for EXPLAIN
+type QueryPlan struct {
+	Root *PlanNode `json:"root"`
+	// Notes are the warnings that follow the plan, e.g. tables missing statistics
+	Notes []string `json:"notes,omitempty"`
+}
+
+var planNodeRegexp = regexp.MustCompile(`^(.*?)\s+\(cost=([\d.]+)\.\.([\d.]+) rows=(\d+) width=(\d+)\)$`)
+
+// ParsePlan parses the lines returned by EXPLAIN into a tree. Steps are indented below
+// their parent and prefixed with "->", other lines are details of the step above them.
+func ParsePlan(lines []string) (*QueryPlan, error) {
+	plan := &QueryPlan{}
+	type level struct {
+		indent int
+		node   *PlanNode
+	}
+	stack := []level{}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "-----") {
+			plan.Notes = append(plan.Notes, strings.TrimSpace(strings.Trim(trimmed, "-")))
+			continue
+		}
+		if len(plan.Notes) > 0 {
+			// lines following a note belong to it, e.g. the list of tables missing statistics
+			plan.Notes[len(plan.Notes)-1] += " " + trimmed
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		isNode := plan.Root == nil || strings.HasPrefix(trimmed, "->")
+		if !isNode {
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unexpected plan line %q", line)
+			}
+			last := stack[len(stack)-1].node
+			last.Details = append(last.Details, trimmed)
+			continue
+		}
+		node, err := parsePlanNode(strings.TrimSpace(strings.TrimPrefix(trimmed, "->")))
+		if err != nil {
+			return nil, err
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		if plan.Root == nil {
+			plan.Root = node
+		} else if len(stack) == 0 {
+			return nil, fmt.Errorf("unexpected plan line %q", line)
+		} else {
+			parent := stack[len(stack)-1].node
+			parent.Children = append(parent.Children, node)
+		}
+		stack = append(stack, level{indent: indent, node: node})
+	}
+	if plan.Root == nil {
+		return nil, fmt.Errorf("empty query plan")
+	}
+	id := 0
+	plan.Walk(func(node, _ *PlanNode) {
+		node.ID = id
+		id++
+	})
+	return plan, nil
+}
+
+func parsePlanNode(s string) (*PlanNode, error) {
+	match := planNodeRegexp.FindStringSubmatch(s)
+	if match == nil {
+		// some steps, e.g. subplans, have no cost
+		return &PlanNode{Operation: s}, nil
+	}
+	node := &PlanNode{Operation: match[1]}
+	var err error
+	if node.StartupCost, err = strconv.ParseFloat(match[2], 64); err != nil {
+		return nil, fmt.Errorf("invalid plan step %q: %w", s, err)
+	}
+	if node.TotalCost, err = strconv.ParseFloat(match[3], 64); err != nil {
+		return nil, fmt.Errorf("invalid plan step %q: %w", s, err)
+	}
+	if node.Rows, err = strconv.ParseInt(match[4], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid plan step %q: %w", s, err)
+	}
+	if node.Width, err = strconv.ParseInt(match[5], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid plan step %q: %w", s, err)
+	}
+	return node, nil
+}
+
+// Walk calls fn on every node of the plan with its parent, which is nil for the root
+func (p *QueryPlan) Walk(fn func(node, parent *PlanNode)) {
+	var walk func(node, parent *PlanNode)
+	walk = func(node, parent *PlanNode) {
+		fn(node, parent)
+		for _, child := range node.Children {
+			walk(child, node)
+		}
+	}
+	walk(p.Root, nil)
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlan(t *testing.T) {
+	lines := []string{
+		"XN Merge  (cost=1000000000136.38..1000000000137.82 rows=576 width=17)",
+		"  Merge Key: sum(sales.pricepaid)",
+		"  ->  XN Network  (cost=1000000000136.38..1000000000137.82 rows=576 width=17)",
+		"        Send to leader",
+		"        ->  XN Hash Join DS_BCAST_INNER  (cost=0.00..136.38 rows=576 width=17)",
+		"              Hash Cond: (\"outer\".listid = \"inner\".listid)",
+		"              ->  XN Seq Scan on listing  (cost=0.00..1924.97 rows=192497 width=14)",
+		"              ->  XN Hash  (cost=0.00..1.00 rows=100 width=8)",
+		"                    ->  XN Seq Scan on sales  (cost=0.00..1.00 rows=100 width=8)",
+		"----- Tables missing statistics: sales -----",
+		"----- Update statistics by running the ANALYZE command on these tables -----",
+	}
+	plan, err := ParsePlan(lines)
+	require.NoError(t, err)
+
+	root := plan.Root
+	assert.Equal(t, "XN Merge", root.Operation)
+	assert.Equal(t, []string{"Merge Key: sum(sales.pricepaid)"}, root.Details)
+	require.Len(t, root.Children, 1)
+	join := root.Children[0].Children[0]
+	assert.Equal(t, "XN Hash Join DS_BCAST_INNER", join.Operation)
+	assert.Equal(t, 136.38, join.TotalCost)
+	assert.Equal(t, int64(576), join.Rows)
+	require.Len(t, join.Children, 2)
+	assert.Equal(t, "XN Seq Scan on listing", join.Children[0].Operation)
+	assert.Equal(t, "XN Seq Scan on sales", join.Children[1].Children[0].Operation)
+	assert.Equal(t, 5, join.Children[1].Children[0].ID)
+	assert.Equal(t, []string{"Tables missing statistics: sales", "Update statistics by running the ANALYZE command on these tables"}, plan.Notes)
+
+	_, err = ParsePlan([]string{})
+	assert.Error(t, err)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
 	"github.com/grafana/grafana-aws-sdk/pkg/sql/models"
@@ -11,9 +13,15 @@ import (
 	"github.com/grafana/sqlds/v5"
 )
 
+// ManagedSecret is a Secrets Manager secret holding database credentials. It can be provisioned by name,
+// or with a partial ARN in place of the ARN, which are resolved to the full ARN when the datasource loads.
 type ManagedSecret struct {
 	Name string `json:"name"`
 	ARN  string `json:"arn"`
+	// Details returned when listing secrets
+	Description     string            `json:"description,omitempty"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	LastRotatedDate *time.Time        `json:"lastRotatedDate,omitempty"`
 }
 
 type RedshiftSecret struct {
@@ -38,6 +46,25 @@ type RedshiftWorkgroup struct {
 	Database      string           `json:"database"`
 }
 
+// RedshiftStatement is a statement run by the datasource
+type RedshiftStatement struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Query      string    `json:"query"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	DurationMs int64     `json:"durationMs"`
+	Rows       int64     `json:"rows"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// CancelResult lists the statements that were canceled and the error of those that could not be
+type CancelResult struct {
+	Canceled []string          `json:"canceled"`
+	Failed   map[string]string `json:"failed,omitempty"`
+}
+
 type RedshiftDataSourceSettings struct {
 	awsds.AWSDatasourceSettings
 	Config            backend.DataSourceInstanceSettings
@@ -49,8 +76,80 @@ type RedshiftDataSourceSettings struct {
 	WithEvent         bool   `json:"withEvent"`
 	DBUser            string `json:"dbUser"`
 	ManagedSecret     ManagedSecret
+	// Retry policy of throttled and transient AWS errors. Zero values fall back to the defaults
+	RetryMaxAttempts int `json:"retryMaxAttempts"`
+	RetryBaseDelayMs int `json:"retryBaseDelayMs"`
+	RetryMaxDelayMs  int `json:"retryMaxDelayMs"`
+	// RetryBudget is the number of retries shared by every query of the datasource, refilled as calls succeed
+	RetryBudget int `json:"retryBudget"`
+	// MaxConcurrentStatements caps the statements the datasource has running at once, the rest are queued
+	MaxConcurrentStatements int `json:"maxConcurrentStatements"`
+	// MaxExecutionTimeSeconds cancels statements still running after this time. Zero disables it
+	MaxExecutionTimeSeconds int `json:"maxExecutionTimeSeconds"`
+	// Session reuse. Idle Data API sessions are kept alive and shared by statements of the same database
+	UseSessionPool          bool `json:"useSessionPool"`
+	SessionKeepAliveSeconds int  `json:"sessionKeepAliveSeconds"`
+	SessionPoolSize         int  `json:"sessionPoolSize"`
+	// SessionInitSQL holds SET statements run before every query, e.g. to set the search_path
+	SessionInitSQL string `json:"sessionInitSQL"`
+	// Statement tagging. Statements are named, and commented, after the Grafana context they are issued from
+	TagStatements bool     `json:"tagStatements"`
+	StatementTags []string `json:"statementTags"`
+	// QueryGroup routes the statements of the datasource to a WLM queue
+	QueryGroup string `json:"queryGroup"`
+	// Result reuse. Queries identical to a recently finished one are served from its result
+	UseResultCache        bool `json:"useResultCache"`
+	ResultCacheTTLSeconds int  `json:"resultCacheTTLSeconds"`
+	// Incremental caching. Time series queries only fetch the part of their time range not covered by the previous run.
+	// Aggregated queries and those sorting rows in descending order or limiting them always fetch their whole range
+	UseIncrementalCache        bool `json:"useIncrementalCache"`
+	IncrementalCacheTTLSeconds int  `json:"incrementalCacheTTLSeconds"`
+	// SplitInterval (e.g. "7d") splits the time range of queries into chunks run in parallel. Queries can override it.
+	// Queries aggregating rows other than into time buckets, sorting them in descending order or limiting them are not split
+	SplitInterval string `json:"splitInterval"`
+	// ReadOnly rejects statements that do not start with one of AllowedStatements, which defaults to ReadOnlyStatements
+	ReadOnly          bool     `json:"readOnly"`
+	AllowedStatements []string `json:"allowedStatements"`
+	// Identity mapping. Queries of mapped Grafana users run with their own database identity, others with
+	// the identity above unless DenyUnmappedUsers is set
+	IdentityMappings  []IdentityMapping `json:"identityMappings"`
+	DenyUnmappedUsers bool              `json:"denyUnmappedUsers"`
+	// Identity propagation. Data API calls use credentials obtained for the OAuth identity Grafana forwards,
+	// by assuming IdentityRoleARN with it ("webIdentity") or through an IAM Identity Center application ("identityCenter")
+	IdentityPropagation          string `json:"identityPropagation"`
+	IdentityRoleARN              string `json:"identityRoleARN"`
+	IdentityCenterApplicationARN string `json:"identityCenterApplicationARN"`
+	// Target overrides. Queries can select one of these clusters, workgroups, database users or managed
+	// secrets (by ARN) instead of the configured one through their connection arguments, e.g. with a
+	// dashboard variable
+	AllowedClusters   []string `json:"allowedClusters"`
+	AllowedWorkgroups []string `json:"allowedWorkgroups"`
+	AllowedDBUsers    []string `json:"allowedDbUsers"`
+	AllowedSecrets    []string `json:"allowedSecrets"`
+	// FailoverTargets are tried in order when the target above is paused, unavailable or throttled.
+	// Their identity is only used for users that are not mapped to their own.
+	FailoverTargets []Target `json:"failoverTargets"`
+	// ResumePausedClusters resumes provisioned clusters found paused by a query, which stays queued,
+	// up to ResumeTimeoutSeconds, until they are available
+	ResumePausedClusters bool `json:"resumePausedClusters"`
+	ResumeTimeoutSeconds int  `json:"resumeTimeoutSeconds"`
+	// Secret discovery. Secrets are listed when they have one of SecretTagKeys, one of SecretTagValues and a name
+	// starting with one of SecretNamePrefixes, for the filters set. Without filters, secrets tagged RedshiftQueryOwner
+	// are listed. SecretARNs are listed too, e.g. secrets shared by other accounts.
+	SecretTagKeys      []string `json:"secretTagKeys"`
+	SecretTagValues    []string `json:"secretTagValues"`
+	SecretNamePrefixes []string `json:"secretNamePrefixes"`
+	SecretARNs         []string `json:"secretARNs"`
 }
 
+// Connection arguments of queries that override the target of the datasource
+const (
+	ArgClusterIdentifier = "clusterIdentifier"
+	ArgWorkgroupName     = "workgroupName"
+	ArgDBUser            = "dbUser"
+	ArgSecretARN         = "secretARN"
+)
+
 func New(_ context.Context) models.Settings {
 	return &RedshiftDataSourceSettings{}
 }
@@ -71,6 +170,33 @@ func (s *RedshiftDataSourceSettings) Load(config backend.DataSourceInstanceSetti
 	return nil
 }
 
+// SessionInitStatements returns the statements of the session initialization SQL,
+// which may only contain SET statements
+func (s *RedshiftDataSourceSettings) SessionInitStatements() ([]string, error) {
+	statements, err := SplitStatements(s.SessionInitSQL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session initialization SQL: %w", err)
+	}
+	for _, stmt := range statements {
+		if StatementKeyword(stmt) != "SET" {
+			return nil, fmt.Errorf("invalid session initialization SQL: only SET statements are allowed, got %q", stmt)
+		}
+	}
+	return statements, nil
+}
+
+// CheckStatements returns an error if the datasource is read-only and the SQL holds a statement it does not allow
+func (s *RedshiftDataSourceSettings) CheckStatements(sql string) error {
+	if !s.ReadOnly {
+		return nil
+	}
+	allowed := s.AllowedStatements
+	if len(allowed) == 0 {
+		allowed = ReadOnlyStatements
+	}
+	return CheckStatements(sql, allowed)
+}
+
 func (s *RedshiftDataSourceSettings) Apply(args sqlds.Options) {
 	region, database := args["region"], args["database"]
 	if region != "" {
@@ -84,4 +210,66 @@ func (s *RedshiftDataSourceSettings) Apply(args sqlds.Options) {
 	if database != "" && database != models.DefaultKey {
 		s.Database = database
 	}
+
+	// the datasource checks overrides before connecting, those not allowed are ignored here
+	if s.CheckOverrides(args) != nil {
+		return
+	}
+	if cluster := override(args, ArgClusterIdentifier); cluster != "" {
+		s.ClusterIdentifier = cluster
+	}
+	if workgroup := override(args, ArgWorkgroupName); workgroup != "" {
+		s.WorkgroupName = workgroup
+	}
+	if dbUser := override(args, ArgDBUser); dbUser != "" {
+		s.DBUser = dbUser
+		s.UseManagedSecret = false
+	}
+	if secret := override(args, ArgSecretARN); secret != "" {
+		s.ManagedSecret = ManagedSecret{ARN: secret}
+		s.UseManagedSecret = true
+	}
+}
+
+func override(args sqlds.Options, key string) string {
+	if value := args[key]; value != models.DefaultKey {
+		return value
+	}
+	return ""
+}
+
+// CheckOverrides returns an error if the connection arguments select a target or identity that the
+// datasource does not allow. The configured ones are always allowed.
+func (s *RedshiftDataSourceSettings) CheckOverrides(args sqlds.Options) error {
+	cluster, workgroup := override(args, ArgClusterIdentifier), override(args, ArgWorkgroupName)
+	dbUser, secret := override(args, ArgDBUser), override(args, ArgSecretARN)
+	switch {
+	case cluster != "" && s.UseServerless:
+		return fmt.Errorf("the datasource targets a serverless workgroup, a cluster cannot be selected")
+	case workgroup != "" && !s.UseServerless:
+		return fmt.Errorf("the datasource targets a provisioned cluster, a workgroup cannot be selected")
+	case dbUser != "" && s.UseServerless:
+		return fmt.Errorf("serverless workgroups do not accept a database user, select a secret instead")
+	case dbUser != "" && secret != "":
+		return fmt.Errorf("select either a database user or a secret")
+	case (dbUser != "" || secret != "") && s.IdentityPropagation != "":
+		return fmt.Errorf("the identity of queries cannot be selected with identity propagation")
+	}
+	overrides := []struct {
+		name    string
+		value   string
+		current string
+		allowed []string
+	}{
+		{"cluster", cluster, s.ClusterIdentifier, s.AllowedClusters},
+		{"workgroup", workgroup, s.WorkgroupName, s.AllowedWorkgroups},
+		{"database user", dbUser, s.DBUser, s.AllowedDBUsers},
+		{"secret", secret, s.ManagedSecret.ARN, s.AllowedSecrets},
+	}
+	for _, o := range overrides {
+		if o.value != "" && o.value != o.current && !slices.Contains(o.allowed, o.value) {
+			return fmt.Errorf("the %s %q is not allowed by the datasource", o.name, o.value)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApply_overrides(t *testing.T) {
+	base := RedshiftDataSourceSettings{
+		ClusterIdentifier: "prod",
+		DBUser:            "grafana",
+		AllowedClusters:   []string{"dev"},
+		AllowedSecrets:    []string{"arn:dev"},
+	}
+
+	s := base
+	s.Apply(map[string]string{ArgClusterIdentifier: "dev", ArgSecretARN: "arn:dev"})
+	assert.Equal(t, "dev", s.ClusterIdentifier)
+	assert.True(t, s.UseManagedSecret)
+	assert.Equal(t, "arn:dev", s.ManagedSecret.ARN)
+
+	s = base
+	s.Apply(map[string]string{ArgClusterIdentifier: "other", "database": "db"})
+	assert.Equal(t, "prod", s.ClusterIdentifier)
+	assert.Equal(t, "db", s.Database)
+
+	s = base
+	s.Apply(map[string]string{ArgClusterIdentifier: "default"})
+	assert.Equal(t, "prod", s.ClusterIdentifier)
+}
+
+func TestCheckOverrides(t *testing.T) {
+	s := &RedshiftDataSourceSettings{ClusterIdentifier: "prod", DBUser: "grafana", AllowedDBUsers: []string{"analyst"}}
+	assert.NoError(t, s.CheckOverrides(map[string]string{ArgClusterIdentifier: "prod", ArgDBUser: "analyst"}))
+	assert.ErrorContains(t, s.CheckOverrides(map[string]string{ArgWorkgroupName: "wg"}), "a workgroup cannot be selected")
+	assert.ErrorContains(t, s.CheckOverrides(map[string]string{ArgDBUser: "analyst", ArgSecretARN: "arn"}), "either a database user or a secret")
+	assert.ErrorContains(t, s.CheckOverrides(map[string]string{ArgDBUser: "admin"}), `the database user "admin" is not allowed`)
+
+	s = &RedshiftDataSourceSettings{UseServerless: true, WorkgroupName: "prod", AllowedWorkgroups: []string{"dev"}}
+	assert.NoError(t, s.CheckOverrides(map[string]string{ArgWorkgroupName: "dev"}))
+	assert.ErrorContains(t, s.CheckOverrides(map[string]string{ArgDBUser: "analyst"}), "serverless workgroups do not accept a database user")
+}
+
+func TestValidateFailoverTargets(t *testing.T) {
+	s := &RedshiftDataSourceSettings{FailoverTargets: []Target{
+		{ClusterIdentifier: "dr", DBUser: "grafana"},
+		{WorkgroupName: "serverless"},
+	}}
+	assert.NoError(t, s.validateFailoverTargets())
+	assert.Equal(t, "workgroup/serverless", s.FailoverTargets[1].Name())
+
+	s = &RedshiftDataSourceSettings{FailoverTargets: []Target{{ClusterIdentifier: "dr", WorkgroupName: "serverless"}}}
+	assert.ErrorContains(t, s.validateFailoverTargets(), "either a cluster or a workgroup")
+
+	s = &RedshiftDataSourceSettings{FailoverTargets: []Target{{ClusterIdentifier: "dr"}}}
+	assert.ErrorContains(t, s.validateFailoverTargets(), "clusters need a database user or a secret ARN")
+
+	s = &RedshiftDataSourceSettings{FailoverTargets: []Target{{WorkgroupName: "serverless", DBUser: "grafana"}}}
+	assert.ErrorContains(t, s.validateFailoverTargets(), "serverless workgroups do not accept a database user")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings RedshiftDataSourceSettings
+		fields   []string
+	}{
+		{
+			name:     "provisioned with temporary credentials",
+			settings: RedshiftDataSourceSettings{ClusterIdentifier: "nightly", Database: "dev", DBUser: "grafana"},
+		},
+		{
+			name:     "provisioned with a managed secret",
+			settings: RedshiftDataSourceSettings{ClusterIdentifier: "nightly", Database: "dev", UseManagedSecret: true, ManagedSecret: ManagedSecret{ARN: "arn:secret"}},
+		},
+		{
+			name:     "serverless with temporary credentials",
+			settings: RedshiftDataSourceSettings{UseServerless: true, WorkgroupName: "serverless", Database: "dev"},
+		},
+		{
+			name:     "serverless with a managed secret",
+			settings: RedshiftDataSourceSettings{UseServerless: true, WorkgroupName: "serverless", Database: "dev", UseManagedSecret: true, ManagedSecret: ManagedSecret{ARN: "arn:secret"}},
+		},
+		{
+			name:     "provisioned with identity propagation",
+			settings: RedshiftDataSourceSettings{ClusterIdentifier: "nightly", Database: "dev", IdentityPropagation: IdentityPropagationWebIdentity, IdentityRoleARN: "arn:role"},
+		},
+		{
+			name:     "empty provisioned",
+			settings: RedshiftDataSourceSettings{},
+			fields:   []string{"clusterIdentifier", "database", "dbUser"},
+		},
+		{
+			name:     "empty serverless",
+			settings: RedshiftDataSourceSettings{UseServerless: true},
+			fields:   []string{"workgroupName", "database"},
+		},
+		{
+			name:     "missing secret",
+			settings: RedshiftDataSourceSettings{ClusterIdentifier: "nightly", Database: "dev", UseManagedSecret: true},
+			fields:   []string{"managedSecret.arn"},
+		},
+		{
+			name: "invalid session initialization SQL",
+			settings: RedshiftDataSourceSettings{ClusterIdentifier: "nightly", Database: "dev", DBUser: "grafana",
+				SessionInitSQL: "DROP TABLE sales"},
+			fields: []string{"sessionInitSQL"},
+		},
+		{
+			name: "invalid identity mapping",
+			settings: RedshiftDataSourceSettings{ClusterIdentifier: "nightly", Database: "dev", DBUser: "grafana",
+				IdentityMappings: []IdentityMapping{{DBUser: "analyst"}}},
+			fields: []string{"identityMappings"},
+		},
+		{
+			name: "invalid identity propagation",
+			settings: RedshiftDataSourceSettings{ClusterIdentifier: "nightly", Database: "dev",
+				IdentityPropagation: IdentityPropagationWebIdentity},
+			fields: []string{"identityPropagation"},
+		},
+		{
+			name: "invalid failover target",
+			settings: RedshiftDataSourceSettings{ClusterIdentifier: "nightly", Database: "dev", DBUser: "grafana",
+				FailoverTargets: []Target{{ClusterIdentifier: "replica"}}},
+			fields: []string{"failoverTargets"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.Validate()
+			if len(tt.fields) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var validationErr *ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			fields := []string{}
+			for _, fieldErr := range validationErr.Errors {
+				fields = append(fields, fieldErr.Field)
+			}
+			assert.Equal(t, tt.fields, fields)
+		})
+	}
+}
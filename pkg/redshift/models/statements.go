@@ -0,0 +1,238 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrUnterminatedSQL is returned for SQL with a quote or a comment that is not closed
+var ErrUnterminatedSQL = errors.New("the SQL has an unterminated quote or comment")
+
+type spanKind int
+
+const (
+	// a single character of SQL outside of quotes and comments
+	spanCode spanKind = iota
+	// a string literal, a dollar-quoted string or a quoted identifier
+	spanQuoted
+	spanComment
+)
+
+// scanSQL calls fn with the spans of the SQL in order: quotes, comments and, in between, single
+// characters of code. Quotes follow the rules of Redshift: a quote is escaped by doubling it and,
+// within string literals, by a backslash, and strings can be dollar-quoted ($$...$$ or $tag$...$tag$).
+// An unterminated quote or comment runs until the end of the SQL, and ErrUnterminatedSQL is returned.
+func scanSQL(sql string, fn func(kind spanKind, span string)) error {
+	var err error
+	for i := 0; i < len(sql); {
+		end, kind, ok := nextSpan(sql, i)
+		if !ok {
+			err = ErrUnterminatedSQL
+		}
+		fn(kind, sql[i:end])
+		i = end
+	}
+	return err
+}
+
+// nextSpan returns the end of the span starting at i, its kind and false if it is unterminated
+func nextSpan(sql string, i int) (int, spanKind, bool) {
+	switch {
+	case sql[i] == '\'':
+		end, ok := quoteEnd(sql, i, '\'', true)
+		return end, spanQuoted, ok
+	case sql[i] == '"':
+		end, ok := quoteEnd(sql, i, '"', false)
+		return end, spanQuoted, ok
+	case sql[i] == '$':
+		if tag := dollarTag(sql, i); tag != "" {
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end < 0 {
+				return len(sql), spanQuoted, false
+			}
+			return i + len(tag) + end + len(tag), spanQuoted, true
+		}
+	case strings.HasPrefix(sql[i:], "--"):
+		end := strings.IndexByte(sql[i:], '\n')
+		if end < 0 {
+			return len(sql), spanComment, true
+		}
+		return i + end, spanComment, true
+	case strings.HasPrefix(sql[i:], "/*"):
+		end := strings.Index(sql[i+2:], "*/")
+		if end < 0 {
+			return len(sql), spanComment, false
+		}
+		return i + 2 + end + 2, spanComment, true
+	}
+	return i + 1, spanCode, true
+}
+
+// quoteEnd returns the end of the quote starting at i, skipping doubled quotes and, if backslash is set,
+// escaped characters
+func quoteEnd(sql string, i int, quote byte, backslash bool) (int, bool) {
+	for j := i + 1; j < len(sql); j++ {
+		switch {
+		case backslash && sql[j] == '\\':
+			j++
+		case sql[j] == quote && j+1 < len(sql) && sql[j+1] == quote:
+			j++
+		case sql[j] == quote:
+			return j + 1, true
+		}
+	}
+	return len(sql), false
+}
+
+// dollarTag returns the tag ($$ or $name$) of the dollar-quoted string starting at i, or an empty
+// string if there is none. A dollar sign within an identifier or followed by a digit does not start one.
+func dollarTag(sql string, i int) string {
+	if i > 0 && isWordChar(sql[i-1]) {
+		return ""
+	}
+	for j := i + 1; j < len(sql); j++ {
+		switch {
+		case sql[j] == '$':
+			return sql[i : j+1]
+		case !isWordChar(sql[j]) || j == i+1 && sql[j] >= '0' && sql[j] <= '9':
+			return ""
+		}
+	}
+	return ""
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// SplitStatements splits a SQL script into its statements. Semicolons within quotes
+// and comments do not end a statement. Empty statements are dropped.
+func SplitStatements(sql string) ([]string, error) {
+	statements := []string{}
+	var current strings.Builder
+	flush := func() {
+		if stmt := strings.TrimSpace(current.String()); stmt != "" {
+			statements = append(statements, stmt)
+		}
+		current.Reset()
+	}
+	err := scanSQL(sql, func(kind spanKind, span string) {
+		if kind == spanCode && span == ";" {
+			flush()
+			return
+		}
+		current.WriteString(span)
+	})
+	flush()
+	return statements, err
+}
+
+// StripComments removes the comments preceding a statement
+func StripComments(stmt string) string {
+	for {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" {
+			return ""
+		}
+		end, kind, ok := nextSpan(stmt, 0)
+		if kind != spanComment {
+			return stmt
+		}
+		if !ok {
+			return ""
+		}
+		stmt = stmt[end:]
+	}
+}
+
+// StatementKeyword returns the first keyword of a statement in upper case
+func StatementKeyword(stmt string) string {
+	stmt = StripComments(stmt)
+	end := strings.IndexFunc(stmt, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	})
+	if end < 0 {
+		end = len(stmt)
+	}
+	return strings.ToUpper(stmt[:end])
+}
+
+// NormalizeSQL removes comments and collapses whitespace outside of quotes, so that queries
+// differing only in their formatting are considered the same
+func NormalizeSQL(sql string) string {
+	var res strings.Builder
+	space := false
+	_ = scanSQL(sql, func(kind spanKind, span string) {
+		if kind == spanComment || kind == spanCode && isSpace(span[0]) {
+			space = true
+			return
+		}
+		if space && res.Len() > 0 {
+			res.WriteByte(' ')
+		}
+		space = false
+		res.WriteString(span)
+	})
+	return res.String()
+}
+
+// ReadOnlyStatements are the statements allowed by default when the datasource is read-only
+var ReadOnlyStatements = []string{"SELECT", "WITH", "SHOW", "EXPLAIN"}
+
+// writeKeywords make a SELECT or WITH statement modify the warehouse, e.g. SELECT INTO creates a table
+var writeKeywords = []string{"INTO", "INSERT", "UPDATE", "DELETE", "MERGE"}
+
+// CheckStatements returns an error if a statement of the SQL does not start with one of the allowed
+// keywords, or is a SELECT or WITH statement that writes data
+func CheckStatements(sql string, allowed []string) error {
+	statements, err := SplitStatements(sql)
+	if err != nil {
+		// where the statements end cannot be told, they are not run
+		return fmt.Errorf("the datasource is read-only: %w", err)
+	}
+	for _, stmt := range statements {
+		keyword := StatementKeyword(strings.TrimLeft(StripComments(stmt), "( \t\r\n"))
+		if !slices.ContainsFunc(allowed, func(a string) bool { return strings.EqualFold(strings.TrimSpace(a), keyword) }) {
+			if keyword == "" {
+				keyword = fmt.Sprintf("%q", stmt)
+			}
+			return fmt.Errorf("the datasource is read-only: %s statements are not allowed, only %s", keyword, strings.Join(allowed, ", "))
+		}
+		if keyword != "SELECT" && keyword != "WITH" {
+			continue
+		}
+		for _, word := range statementWords(stmt) {
+			if slices.Contains(writeKeywords, word) {
+				return fmt.Errorf("the datasource is read-only: %s statements with %s are not allowed", keyword, word)
+			}
+		}
+	}
+	return nil
+}
+
+// statementWords returns the words of a statement outside of comments and quotes, in upper case
+func statementWords(stmt string) []string {
+	words := []string{}
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, strings.ToUpper(word.String()))
+			word.Reset()
+		}
+	}
+	_ = scanSQL(stmt, func(kind spanKind, span string) {
+		if kind == spanCode && isWordChar(span[0]) {
+			word.WriteString(span)
+			return
+		}
+		flush()
+	})
+	flush()
+	return words
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		description string
+		sql         string
+		expected    []string
+	}{
+		{
+			description: "single statement",
+			sql:         "SET search_path TO foo",
+			expected:    []string{"SET search_path TO foo"},
+		},
+		{
+			description: "multiple statements",
+			sql:         "SET search_path TO foo;\n SET timezone TO 'UTC';",
+			expected:    []string{"SET search_path TO foo", "SET timezone TO 'UTC'"},
+		},
+		{
+			description: "semicolons in quotes and comments",
+			sql:         "SET query_group TO 'a;b'; -- comment;\nSELECT \"x;y\" /* ; */ FROM t",
+			expected:    []string{"SET query_group TO 'a;b'", "-- comment;\nSELECT \"x;y\" /* ; */ FROM t"},
+		},
+		{
+			description: "escaped and dollar quotes",
+			sql:         `SELECT 'a\';b''c;'; SELECT $$;$$, $t$;$t$`,
+			expected:    []string{`SELECT 'a\';b''c;'`, "SELECT $$;$$, $t$;$t$"},
+		},
+		{
+			description: "empty",
+			sql:         " ; ",
+			expected:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			statements, err := SplitStatements(tt.sql)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, statements)
+		})
+	}
+}
+
+func TestStatementKeyword(t *testing.T) {
+	assert.Equal(t, "SELECT", StatementKeyword("/* tag */ -- comment\n select 1"))
+	assert.Equal(t, "WITH", StatementKeyword("with t as (select 1) select * from t"))
+	assert.Equal(t, "", StatementKeyword("-- only a comment"))
+}
+
+func TestSessionInitStatements(t *testing.T) {
+	s := &RedshiftDataSourceSettings{SessionInitSQL: "SET search_path TO foo; set timezone TO 'UTC'"}
+	statements, err := s.SessionInitStatements()
+	assert.NoError(t, err)
+	assert.Len(t, statements, 2)
+
+	s = &RedshiftDataSourceSettings{SessionInitSQL: "SET search_path TO foo; DROP TABLE bar"}
+	_, err = s.SessionInitStatements()
+	assert.Error(t, err)
+}
+
+func TestCheckStatements(t *testing.T) {
+	tests := []struct {
+		sql string
+		err string
+	}{
+		{sql: "select * from foo"},
+		{sql: "-- comment\nWITH t AS (SELECT 1) SELECT * FROM t"},
+		{sql: "(select 1) union (select 2)"},
+		{sql: "show tables from schema dev.public; explain select 1"},
+		{sql: "select 'insert into' as \"update\" from foo"},
+		{sql: "DROP TABLE foo", err: "DROP statements are not allowed"},
+		{sql: "select 1; delete from foo", err: "DELETE statements are not allowed"},
+		{sql: "select * into bar from foo", err: "SELECT statements with INTO are not allowed"},
+		{sql: `select 'it''s', 'C:\\' from foo`},
+		{sql: "select $$it's$$, $body$;$body$ from foo"},
+		{sql: "select a$b$ from foo where b = $1"},
+		// quoting that would hide a write if it were parsed differently than Redshift does
+		{sql: "SELECT 'a\\'' INTO evil FROM t --'", err: "SELECT statements with INTO are not allowed"},
+		{sql: "SELECT 'a\\''; DROP TABLE t; --'", err: "DROP statements are not allowed"},
+		{sql: "SELECT $$'$$ INTO evil2", err: "SELECT statements with INTO are not allowed"},
+		{sql: "SELECT $x$ $$ $x$ INTO evil3 FROM t -- $x$", err: "SELECT statements with INTO are not allowed"},
+		{sql: "SELECT 'a''' INTO evil4 FROM t", err: "SELECT statements with INTO are not allowed"},
+		{sql: `SELECT "a""" INTO evil5 FROM t`, err: "SELECT statements with INTO are not allowed"},
+		{sql: "SELECT 'a\\'; DROP TABLE t", err: "unterminated quote or comment"},
+		{sql: "SELECT $$a; DROP TABLE t", err: "unterminated quote or comment"},
+		{sql: "SELECT 1 /* ; DROP TABLE t", err: "unterminated quote or comment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			err := CheckStatements(tt.sql, ReadOnlyStatements)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+
+	s := &RedshiftDataSourceSettings{}
+	assert.NoError(t, s.CheckStatements("DROP TABLE foo"))
+	s = &RedshiftDataSourceSettings{ReadOnly: true, AllowedStatements: []string{"select", "call"}}
+	assert.NoError(t, s.CheckStatements("CALL refresh()"))
+	assert.Error(t, s.CheckStatements("SHOW TABLES FROM SCHEMA dev.public"))
+}
+
+func TestNormalizeSQL(t *testing.T) {
+	assert.Equal(t, "SELECT a, 'x  -- y' FROM t WHERE b = 1",
+		NormalizeSQL("/* grafana panelID=2 */\nSELECT a,  'x  -- y'\n\tFROM t -- comment\nWHERE b = 1\n"))
+	assert.Equal(t, NormalizeSQL("select 1"), NormalizeSQL(" select\n1 "))
+	assert.Equal(t, `SELECT 'a\' -- b', $$ /* c */ $$`, NormalizeSQL("SELECT  'a\\' -- b',\n$$ /* c */ $$"))
+}
+
+func TestSplitStatements_unterminated(t *testing.T) {
+	statements, err := SplitStatements("SELECT 1; SELECT 'a")
+	assert.ErrorIs(t, err, ErrUnterminatedSQL)
+	assert.Equal(t, []string{"SELECT 1", "SELECT 'a"}, statements)
+}
@@ -0,0 +1,50 @@
+package models
+
+import "fmt"
+
+// Target is a cluster or workgroup the datasource fails over to, with the identity used to query it
+type Target struct {
+	ClusterIdentifier string `json:"clusterIdentifier"`
+	WorkgroupName     string `json:"workgroupName"`
+	// Database defaults to the database of the datasource
+	Database  string `json:"database"`
+	DBUser    string `json:"dbUser"`
+	SecretARN string `json:"secretARN"`
+}
+
+// Name identifies the target in frame metadata
+func (t Target) Name() string {
+	if t.WorkgroupName != "" {
+		return "workgroup/" + t.WorkgroupName
+	}
+	return "cluster/" + t.ClusterIdentifier
+}
+
+// hasFailoverWorkgroup returns true if a failover target is a serverless workgroup
+func (s *RedshiftDataSourceSettings) hasFailoverWorkgroup() bool {
+	for _, target := range s.FailoverTargets {
+		if target.WorkgroupName != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateFailoverTargets checks that every target has a cluster or a workgroup, and an identity it accepts
+func (s *RedshiftDataSourceSettings) validateFailoverTargets() error {
+	for i, target := range s.FailoverTargets {
+		switch {
+		case (target.ClusterIdentifier == "") == (target.WorkgroupName == ""):
+			return fmt.Errorf("invalid failover target %d: set either a cluster or a workgroup", i+1)
+		case target.DBUser != "" && target.SecretARN != "":
+			return fmt.Errorf("invalid failover target %d: set either a database user or a secret ARN", i+1)
+		case s.IdentityPropagation != "" && (target.DBUser != "" || target.SecretARN != ""):
+			return fmt.Errorf("invalid failover target %d: the identity of the user is propagated, remove its database user and secret", i+1)
+		case target.WorkgroupName != "" && target.DBUser != "":
+			return fmt.Errorf("invalid failover target %d: serverless workgroups do not accept a database user, use a secret instead", i+1)
+		case target.ClusterIdentifier != "" && target.DBUser == "" && target.SecretARN == "" && s.IdentityPropagation == "":
+			return fmt.Errorf("invalid failover target %d: clusters need a database user or a secret ARN", i+1)
+		}
+	}
+	return nil
+}
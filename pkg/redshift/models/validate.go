@@ -0,0 +1,89 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError is an invalid setting, identified by its JSON name
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the invalid settings of a datasource
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		msgs[i] = fmt.Sprintf("%s: %s", fieldErr.Field, fieldErr.Message)
+	}
+	return "invalid datasource settings: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks that the settings hold the target and identity queries need, for every combination of
+// provisioned cluster or serverless workgroup and temporary credentials or managed secret, and that the
+// session initialization SQL, identity mappings, identity propagation and failover targets are valid. Load
+// only parses the settings, so that the configuration page can list clusters, workgroups and secrets while
+// the settings are incomplete or invalid.
+func (s *RedshiftDataSourceSettings) Validate() error {
+	res := &ValidationError{}
+	add := func(field, msg string) {
+		res.Errors = append(res.Errors, FieldError{Field: field, Message: msg})
+	}
+	if s.UseServerless {
+		if s.WorkgroupName == "" {
+			add("workgroupName", "a workgroup is required")
+		}
+	} else if s.ClusterIdentifier == "" {
+		add("clusterIdentifier", "a cluster identifier is required")
+	}
+	if s.Database == "" {
+		add("database", "a database is required")
+	}
+	switch {
+	case s.UseManagedSecret && s.ManagedSecret.ARN == "" && s.ManagedSecret.Name == "":
+		add("managedSecret.arn", "a secret name or ARN is required to authenticate with a managed secret")
+	case !s.UseManagedSecret && !s.UseServerless && s.DBUser == "" && !s.identityWithoutDBUser():
+		add("dbUser", "a database user is required to authenticate a provisioned cluster with temporary credentials")
+	}
+	if _, err := s.SessionInitStatements(); err != nil {
+		add("sessionInitSQL", err.Error())
+	}
+	if err := s.validateIdentityMappings(); err != nil {
+		add("identityMappings", err.Error())
+	}
+	if err := s.validateIdentityPropagation(); err != nil {
+		add("identityPropagation", err.Error())
+	}
+	if err := s.validateFailoverTargets(); err != nil {
+		add("failoverTargets", err.Error())
+	}
+	if len(res.Errors) > 0 {
+		return res
+	}
+	return nil
+}
+
+// Warnings lists the settings the datasource ignores. Unlike the errors of Validate, they do not prevent
+// queries from running: teams in identity mappings are ignored since Grafana does not pass the teams of a
+// user to plugins, their members run queries like unmapped users.
+func (s *RedshiftDataSourceSettings) Warnings() []FieldError {
+	warnings := []FieldError{}
+	for _, i := range s.mappedTeams() {
+		warnings = append(warnings, FieldError{
+			Field:   "identityMappings",
+			Message: fmt.Sprintf("the teams of identity mapping %d are ignored because Grafana does not pass the teams of a user to plugins, list the logins or emails of the team members instead", i),
+		})
+	}
+	return warnings
+}
+
+// identityWithoutDBUser returns true if queries never run as the database user of the datasource, because
+// the identity of users is propagated or only mapped users are allowed
+func (s *RedshiftDataSourceSettings) identityWithoutDBUser() bool {
+	return s.IdentityPropagation != "" || (s.DenyUnmappedUsers && len(s.IdentityMappings) > 0)
+}
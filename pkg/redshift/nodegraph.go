@@ -0,0 +1,97 @@
+package redshift
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+)
+
+// queryTypeNodeGraph returns the plan of the query instead of its result. It is a query type rather
+// than a format since sqlutil rejects formats it does not know.
+const queryTypeNodeGraph = "nodeGraph"
+
+type explainFunc func(ctx context.Context, query *sqlutil.Query) (*models.QueryPlan, error)
+
+// nodeGraphQueryData wraps fn, which runs requests made of a single query, so that queries of the
+// node graph type return the plan of their SQL as frames for the Node Graph panel
+func nodeGraphQueryData(explain explainFunc, fn queryDataFunc) queryDataFunc {
+	return func(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		q := req.Queries[0]
+		if q.QueryType != queryTypeNodeGraph {
+			return fn(ctx, req)
+		}
+		res := backend.NewQueryDataResponse()
+		query, err := sqlutil.GetQuery(q)
+		if err != nil {
+			res.Responses[q.RefID] = backend.ErrorResponseWithErrorSource(err)
+			return res, nil
+		}
+		plan, err := explain(ctx, query)
+		if err != nil {
+			res.Responses[q.RefID] = backend.ErrorResponseWithErrorSource(err)
+			return res, nil
+		}
+		res.Responses[q.RefID] = backend.DataResponse{Frames: planFrames(plan, q.RefID)}
+		return res, nil
+	}
+}
+
+// planFrames returns the nodes and edges frames of a plan. Nodes show the cost and rows of each step,
+// edges go from a step to the steps it reads from.
+func planFrames(plan *models.QueryPlan, refID string) data.Frames {
+	ids := []string{}
+	titles := []string{}
+	costs := []float64{}
+	rows := []int64{}
+	startupCosts := []float64{}
+	widths := []int64{}
+	details := []string{}
+	edgeIDs := []string{}
+	sources := []string{}
+	targets := []string{}
+	plan.Walk(func(node, parent *models.PlanNode) {
+		id := fmt.Sprint(node.ID)
+		ids = append(ids, id)
+		titles = append(titles, node.Operation)
+		costs = append(costs, node.TotalCost)
+		rows = append(rows, node.Rows)
+		startupCosts = append(startupCosts, node.StartupCost)
+		widths = append(widths, node.Width)
+		details = append(details, strings.Join(node.Details, "\n"))
+		if parent != nil {
+			parentID := fmt.Sprint(parent.ID)
+			edgeIDs = append(edgeIDs, parentID+"-"+id)
+			sources = append(sources, parentID)
+			targets = append(targets, id)
+		}
+	})
+
+	nodes := data.NewFrame("nodes",
+		data.NewField("id", nil, ids),
+		data.NewField("title", nil, titles),
+		data.NewField("mainstat", nil, costs).SetConfig(&data.FieldConfig{DisplayName: "Cost"}),
+		data.NewField("secondarystat", nil, rows).SetConfig(&data.FieldConfig{DisplayName: "Rows"}),
+		data.NewField("detail__startupCost", nil, startupCosts).SetConfig(&data.FieldConfig{DisplayName: "Startup cost"}),
+		data.NewField("detail__width", nil, widths).SetConfig(&data.FieldConfig{DisplayName: "Width"}),
+		data.NewField("detail__details", nil, details).SetConfig(&data.FieldConfig{DisplayName: "Details"}),
+	)
+	nodes.RefID = refID
+	nodes.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph}
+	for _, note := range plan.Notes {
+		nodes.AppendNotices(data.Notice{Severity: data.NoticeSeverityWarning, Text: note})
+	}
+
+	edges := data.NewFrame("edges",
+		data.NewField("id", nil, edgeIDs),
+		data.NewField("source", nil, sources),
+		data.NewField("target", nil, targets),
+	)
+	edges.RefID = refID
+	edges.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph}
+	return data.Frames{nodes, edges}
+}
@@ -0,0 +1,55 @@
+package redshift
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/grafana/redshift-datasource/pkg/redshift/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_nodeGraphQueryData(t *testing.T) {
+	plan, err := models.ParsePlan([]string{
+		"XN Hash Join DS_BCAST_INNER  (cost=0.00..136.38 rows=576 width=17)",
+		"  Hash Cond: (\"outer\".listid = \"inner\".listid)",
+		"  ->  XN Seq Scan on listing  (cost=0.00..1924.97 rows=192497 width=14)",
+		"  ->  XN Hash  (cost=0.00..1.00 rows=100 width=8)",
+		"        ->  XN Seq Scan on sales  (cost=0.00..1.00 rows=100 width=8)",
+	})
+	require.NoError(t, err)
+	var explained string
+	explain := func(_ context.Context, query *sqlutil.Query) (*models.QueryPlan, error) {
+		explained = query.RawSQL
+		return plan, nil
+	}
+	queried := false
+	fn := func(context.Context, *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		queried = true
+		return backend.NewQueryDataResponse(), nil
+	}
+
+	req := &backend.QueryDataRequest{Queries: []backend.DataQuery{{RefID: "A", QueryType: queryTypeNodeGraph, JSON: []byte(`{"rawSQL":"select 1"}`)}}}
+	res, err := nodeGraphQueryData(explain, fn)(context.Background(), req)
+	require.NoError(t, err)
+	assert.False(t, queried)
+	assert.Equal(t, "select 1", explained)
+
+	frames := res.Responses["A"].Frames
+	require.Len(t, frames, 2)
+	nodes, edges := frames[0], frames[1]
+	assert.Equal(t, 4, nodes.Rows())
+	assert.Equal(t, "XN Seq Scan on sales", nodes.Fields[1].At(3))
+	assert.Equal(t, 136.38, nodes.Fields[2].At(0))
+	assert.Equal(t, `Hash Cond: ("outer".listid = "inner".listid)`, nodes.Fields[6].At(0))
+	require.Equal(t, 3, edges.Rows())
+	assert.Equal(t, "2", edges.Fields[1].At(2))
+	assert.Equal(t, "3", edges.Fields[2].At(2))
+
+	req.Queries[0].QueryType = ""
+	_, err = nodeGraphQueryData(explain, fn)(context.Background(), req)
+	require.NoError(t, err)
+	assert.True(t, queried)
+}
@@ -0,0 +1,282 @@
+package redshift
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// maxParallelParts bounds the parts of a query running at once. The datasource
+	// scheduler still applies its own limit on top of it.
+	maxParallelParts = 8
+	// partQueryTTL is how long the parts of a query run with the async query flow are
+	// remembered for the requests polling it
+	partQueryTTL = time.Hour
+)
+
+var errUnknownPartQuery = errors.New("the query is no longer known, run it again")
+
+// combineFunc returns the response of a query from the responses of its parts, in order
+type combineFunc func(responses []backend.DataResponse) backend.DataResponse
+
+// partQueries runs queries as several parts, e.g. the chunks of a split query or the delta of a cached
+// one. With the async query flow, the request starting a query starts its parts and returns an ID of
+// its own, and the requests polling that ID poll the parts until they have all finished.
+type partQueries struct {
+	// prefix of the IDs of the queries, which tells them apart from those of the parts
+	prefix string
+
+	mu      sync.Mutex
+	queries map[string]*partQuery
+}
+
+type partQuery struct {
+	identity string
+	parts    []backend.DataQuery
+	combine  combineFunc
+	expires  time.Time
+
+	mu        sync.Mutex
+	responses []*backend.DataResponse
+}
+
+func newPartQueries(prefix string) *partQueries {
+	return &partQueries{prefix: prefix, queries: map[string]*partQuery{}}
+}
+
+// run runs the parts of the query of the request with fn, which runs requests made of a single query,
+// and returns the response combined from theirs. With the async query flow, it starts the parts and
+// returns the ID the query is polled with instead.
+func (p *partQueries) run(ctx context.Context, fn queryDataFunc, req *backend.QueryDataRequest, parts []backend.DataQuery, combine combineFunc) (*backend.QueryDataResponse, error) {
+	q := req.Queries[0]
+	responses, err := runParts(ctx, fn, req, parts)
+	if err != nil {
+		return nil, err
+	}
+	res := backend.NewQueryDataResponse()
+	for _, r := range responses {
+		if r.Error != nil {
+			res.Responses[q.RefID] = r
+			return res, nil
+		}
+	}
+	if !isAsyncFlow(req) {
+		res.Responses[q.RefID] = combine(responses)
+		return res, nil
+	}
+	started := make([]backend.DataQuery, len(parts))
+	for i, r := range responses {
+		meta, ok := responseMeta(r)
+		if !ok {
+			res.Responses[q.RefID] = backend.ErrorResponseWithErrorSource(backend.PluginError(errors.New("a part of the query did not return its query ID")))
+			return res, nil
+		}
+		if started[i], err = withQueryID(parts[i], meta.QueryID); err != nil {
+			return nil, err
+		}
+	}
+	id, err := p.add(&partQuery{
+		identity:  queryIdentity(req.PluginContext),
+		parts:     started,
+		combine:   combine,
+		responses: make([]*backend.DataResponse, len(parts)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Responses[q.RefID] = statusResponse(q, id, "started")
+	return res, nil
+}
+
+// poll polls the parts of the query the request polls, and returns the combined response once they
+// have all finished. It returns false if the request does not poll a query started by run.
+func (p *partQueries) poll(ctx context.Context, fn queryDataFunc, req *backend.QueryDataRequest) (*backend.QueryDataResponse, bool, error) {
+	q := req.Queries[0]
+	query, err := awsds.GetQuery(q)
+	if err != nil || !strings.HasPrefix(query.QueryID, p.prefix) {
+		return nil, false, nil
+	}
+	res := backend.NewQueryDataResponse()
+	pq, ok := p.get(query.QueryID)
+	if !ok || pq.identity != queryIdentity(req.PluginContext) {
+		res.Responses[q.RefID] = backend.ErrorResponseWithErrorSource(backend.DownstreamError(errUnknownPartQuery))
+		return res, true, nil
+	}
+
+	pending := []int{}
+	pq.mu.Lock()
+	for i, r := range pq.responses {
+		if r == nil {
+			pending = append(pending, i)
+		}
+	}
+	pq.mu.Unlock()
+	parts := make([]backend.DataQuery, len(pending))
+	for i, part := range pending {
+		parts[i] = pq.parts[part]
+	}
+	responses, err := runParts(ctx, fn, req, parts)
+	if err != nil {
+		return nil, true, err
+	}
+
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	for i, r := range responses {
+		meta, _ := responseMeta(r)
+		switch {
+		case r.Error != nil, meta.Status == awsds.QueryCanceled.String(), meta.Status == awsds.QueryFailed.String():
+			p.delete(query.QueryID)
+			res.Responses[q.RefID] = r
+			return res, true, nil
+		case meta.Status == awsds.QueryFinished.String():
+			pq.responses[pending[i]] = &r
+		}
+	}
+	finished := make([]backend.DataResponse, len(pq.responses))
+	for i, r := range pq.responses {
+		if r == nil {
+			res.Responses[q.RefID] = statusResponse(q, query.QueryID, awsds.QueryRunning.String())
+			return res, true, nil
+		}
+		finished[i] = *r
+	}
+	p.delete(query.QueryID)
+	r := pq.combine(finished)
+	if r.Error == nil {
+		// like a query that is not run in parts, the first frame tells the frontend that it finished
+		if len(r.Frames) == 0 {
+			r.Frames = data.Frames{data.NewFrame("")}
+		}
+		if r.Frames[0].Meta == nil {
+			r.Frames[0].Meta = &data.FrameMeta{}
+		}
+		r.Frames[0].Meta.Custom = queryMeta{QueryID: query.QueryID, Status: awsds.QueryFinished.String()}
+	}
+	res.Responses[q.RefID] = r
+	return res, true, nil
+}
+
+func (p *partQueries) add(pq *partQuery) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := p.prefix + hex.EncodeToString(b)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for k, q := range p.queries {
+		if now.After(q.expires) {
+			delete(p.queries, k)
+		}
+	}
+	pq.expires = now.Add(partQueryTTL)
+	p.queries[id] = pq
+	return id, nil
+}
+
+func (p *partQueries) get(id string) (*partQuery, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pq, ok := p.queries[id]
+	if !ok || time.Now().After(pq.expires) {
+		return nil, false
+	}
+	return pq, true
+}
+
+func (p *partQueries) delete(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.queries, id)
+}
+
+// isPoll returns true if the query polls a query started with the async query flow
+func isPoll(q backend.DataQuery) bool {
+	query, err := awsds.GetQuery(q)
+	return err == nil && query.QueryID != ""
+}
+
+// runParts runs each part as a request of its own, in parallel, and returns their responses in order
+func runParts(ctx context.Context, fn queryDataFunc, req *backend.QueryDataRequest, parts []backend.DataQuery) ([]backend.DataResponse, error) {
+	responses := make([]backend.DataResponse, len(parts))
+	errs := make([]error, len(parts))
+	sem := make(chan struct{}, maxParallelParts)
+	var wg sync.WaitGroup
+	for i, part := range parts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			res, err := fn(ctx, &backend.QueryDataRequest{PluginContext: req.PluginContext, Headers: req.Headers, Queries: []backend.DataQuery{part}})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			responses[i] = res.Responses[part.RefID]
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return responses, nil
+}
+
+// isAsyncFlow returns true if the query of the request runs with the async query flow. Like the async
+// AWS datasource, queries of alerts and expressions run with the sync query flow.
+func isAsyncFlow(req *backend.QueryDataRequest) bool {
+	if _, ok := req.Headers["FromAlert"]; ok {
+		return false
+	}
+	if _, ok := req.Headers["http_X-Grafana-From-Expr"]; ok {
+		return false
+	}
+	query, err := awsds.GetQuery(req.Queries[0])
+	return err == nil && query.Meta.QueryFlow == "async"
+}
+
+// responseMeta returns the query ID and status of a response of the async query flow
+func responseMeta(r backend.DataResponse) (queryMeta, bool) {
+	if len(r.Frames) == 0 || r.Frames[0].Meta == nil || r.Frames[0].Meta.Custom == nil {
+		return queryMeta{}, false
+	}
+	return parseQueryMeta(r.Frames[0].Meta)
+}
+
+// withQueryID returns the part with the query ID it is polled with set in its model
+func withQueryID(part backend.DataQuery, queryID string) (backend.DataQuery, error) {
+	model := map[string]interface{}{}
+	if err := json.Unmarshal(part.JSON, &model); err != nil {
+		return part, err
+	}
+	model["queryID"] = queryID
+	b, err := json.Marshal(model)
+	if err != nil {
+		return part, err
+	}
+	part.JSON = b
+	return part, nil
+}
+
+// statusResponse returns the response of the async query flow for a query that has not finished
+func statusResponse(q backend.DataQuery, queryID, status string) backend.DataResponse {
+	frame := data.NewFrame("")
+	frame.Meta = &data.FrameMeta{Custom: queryMeta{QueryID: queryID, Status: status}}
+	if query, err := awsds.GetQuery(q); err == nil {
+		frame.Meta.ExecutedQueryString = query.RawSQL
+	}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
@@ -1,10 +1,17 @@
 package routes
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/grafana/grafana-aws-sdk/pkg/sql/routes"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
 	"github.com/grafana/redshift-datasource/pkg/redshift"
+	"github.com/grafana/redshift-datasource/pkg/redshift/api"
 	"github.com/grafana/sqlds/v5"
 )
 
@@ -43,11 +50,139 @@ func (r *RedshiftResourceHandler) workgroups(rw http.ResponseWriter, req *http.R
 	routes.SendResources(rw, workgroups, err)
 }
 
+// history returns the statements recently run by the datasource. Only admins can list them, since
+// they include the SQL of the queries of every user.
+func (r *RedshiftResourceHandler) history(rw http.ResponseWriter, req *http.Request, options sqlds.Options) {
+	history, err := r.redshift.History(req.Context(), options)
+	routes.SendResources(rw, history, err)
+}
+
+// running returns the statements of the datasource still running. Only admins can list them.
+func (r *RedshiftResourceHandler) running(rw http.ResponseWriter, req *http.Request, options sqlds.Options) {
+	running, err := r.redshift.Running(req.Context(), options)
+	routes.SendResources(rw, running, err)
+}
+
+// cancel cancels running statements of the datasource. Only admins can cancel queries of other users.
+func (r *RedshiftResourceHandler) cancel(rw http.ResponseWriter, req *http.Request, options sqlds.Options) {
+	res, err := r.redshift.CancelStatements(req.Context(), options)
+	routes.SendResources(rw, res, err)
+}
+
+// permissions probes the API actions the datasource uses. Only admins can probe them, since a statement is run.
+// The options are connection arguments, to probe another target or identity.
+func (r *RedshiftResourceHandler) permissions(rw http.ResponseWriter, req *http.Request, options sqlds.Options) {
+	res, err := r.redshift.Permissions(req.Context(), options)
+	routes.SendResources(rw, res, err)
+}
+
+// explain returns the plan of the "rawSQL" option. Its macros are expanded with the "from" and "to" times, in
+// epoch milliseconds, and the "schema", "table" and "column" options. Other options are connection arguments.
+func (r *RedshiftResourceHandler) explain(rw http.ResponseWriter, req *http.Request, options sqlds.Options) {
+	query, err := explainQuery(options)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		routes.Write(rw, []byte(err.Error()))
+		return
+	}
+	plan, err := r.redshift.Explain(req.Context(), query)
+	routes.SendResources(rw, plan, err)
+}
+
+func explainQuery(options sqlds.Options) (*sqlutil.Query, error) {
+	query := &sqlutil.Query{
+		RawSQL: options["rawSQL"],
+		Schema: options["schema"],
+		Table:  options["table"],
+		Column: options["column"],
+	}
+	if query.RawSQL == "" {
+		return nil, fmt.Errorf("missing rawSQL")
+	}
+	for key, t := range map[string]*time.Time{"from": &query.TimeRange.From, "to": &query.TimeRange.To} {
+		if options[key] == "" {
+			continue
+		}
+		ms, err := strconv.ParseInt(options[key], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s time %q, expected epoch milliseconds", key, options[key])
+		}
+		*t = time.UnixMilli(ms)
+	}
+	args := sqlds.Options{}
+	for key, value := range options {
+		switch key {
+		case "rawSQL", "schema", "table", "column", "from", "to":
+		default:
+			args[key] = value
+		}
+	}
+	connectionArgs, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	query.ConnectionArgs = connectionArgs
+	return query, nil
+}
+
+// parseOptions reads the options of a request from its JSON body or, if it has none, from its URL query
+func parseOptions(req *http.Request) (sqlds.Options, error) {
+	if req.Body != nil && req.ContentLength != 0 {
+		return routes.ParseBody(req.Body)
+	}
+	options := sqlds.Options{}
+	for key := range req.URL.Query() {
+		options[key] = req.URL.Query().Get(key)
+	}
+	return options, nil
+}
+
+// withOptions passes the options of the request to the handler, see parseOptions
+func withOptions(handler func(http.ResponseWriter, *http.Request, sqlds.Options)) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		options, err := parseOptions(req)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			routes.Write(rw, []byte(err.Error()))
+			return
+		}
+		handler(rw, req, options)
+	}
+}
+
+// requireAdmin restricts a route to admins
+func requireAdmin(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if user := backend.UserFromContext(req.Context()); user == nil || user.Role != "Admin" {
+			rw.WriteHeader(http.StatusForbidden)
+			routes.Write(rw, []byte("only admins can use this route"))
+			return
+		}
+		handler(rw, req)
+	}
+}
+
+// withIdentityToken passes the OAuth identity forwarded by Grafana to the Data API calls of a route
+func withIdentityToken(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		token := api.IdentityToken(req.Header.Get(backend.OAuthIdentityIDTokenHeaderName), req.Header.Get(backend.OAuthIdentityTokenHeaderName))
+		handler(rw, req.WithContext(api.WithIdentityToken(req.Context(), token)))
+	}
+}
+
 func (r *RedshiftResourceHandler) Routes() map[string]func(http.ResponseWriter, *http.Request) {
 	routes := r.DefaultRoutes()
 	routes["/secrets"] = r.secrets
 	routes["/secret"] = r.secret
 	routes["/clusters"] = r.clusters
 	routes["/workgroups"] = r.workgroups
+	routes["/history"] = requireAdmin(withOptions(r.history))
+	routes["/running"] = requireAdmin(withOptions(r.running))
+	routes["/cancel"] = requireAdmin(withOptions(r.cancel))
+	routes["/explain"] = withOptions(r.explain)
+	routes["/permissions"] = requireAdmin(withOptions(r.permissions))
+	for path, handler := range routes {
+		routes[path] = withIdentityToken(handler)
+	}
 	return routes
 }
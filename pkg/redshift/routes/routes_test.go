@@ -6,8 +6,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/redshift-datasource/pkg/redshift/fake"
 	"github.com/grafana/redshift-datasource/pkg/redshift/models"
 	"github.com/stretchr/testify/assert"
@@ -38,12 +40,34 @@ var ds = &fake.RedshiftFakeDatasource{
 			Database: "db-bar",
 		},
 	},
+	RHistory: []models.RedshiftStatement{
+		{
+			ID:         "id",
+			Name:       "grafana:uid:panelID=2",
+			Query:      "select 1",
+			Status:     "FINISHED",
+			CreatedAt:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			UpdatedAt:  time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC),
+			DurationMs: 1000,
+			Rows:       1,
+		},
+	},
+	RRunning: []models.RedshiftStatement{
+		{ID: "running", Name: "grafana:uid:dashboardUID=abc", Status: "STARTED"},
+	},
+	RPlan: &models.QueryPlan{Root: &models.PlanNode{Operation: "XN Seq Scan on t", TotalCost: 1, Rows: 10, Width: 4}},
+	RPermissions: &models.PermissionReport{
+		Actions: []models.ActionPermission{{Action: "redshift-data:ListSchemas", Status: models.PermissionDenied}},
+		Missing: []string{"redshift-data:ListSchemas"},
+	},
 }
 
 func TestRoutes(t *testing.T) {
 	tests := []struct {
 		description    string
 		route          string
+		body           string
+		user           *backend.User
 		expectedCode   int
 		expectedResult string
 	}{
@@ -71,24 +95,86 @@ func TestRoutes(t *testing.T) {
 			expectedCode:   http.StatusOK,
 			expectedResult: `[{"workgroupName":"bar","endpoint":{"address":"bar.a.b.c","port":456},"database":"db-bar"}]`,
 		},
+		{
+			description:    "return history",
+			route:          "history",
+			user:           &backend.User{Role: "Admin"},
+			expectedCode:   http.StatusOK,
+			expectedResult: `[{"id":"id","name":"grafana:uid:panelID=2","query":"select 1","status":"FINISHED","createdAt":"2024-01-01T00:00:00Z","updatedAt":"2024-01-01T00:00:01Z","durationMs":1000,"rows":1}]`,
+		},
+		{
+			description:    "return running statements",
+			route:          "running",
+			user:           &backend.User{Role: "Admin"},
+			expectedCode:   http.StatusOK,
+			expectedResult: `[{"id":"running","name":"grafana:uid:dashboardUID=abc","query":"","status":"STARTED","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z","durationMs":0,"rows":0}]`,
+		},
+		{
+			description:  "forbid viewers to list the history",
+			route:        "history",
+			user:         &backend.User{Role: "Viewer"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			description:  "forbid editors to list running statements",
+			route:        "running",
+			user:         &backend.User{Role: "Editor"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			description:    "cancel statements",
+			route:          "cancel",
+			user:           &backend.User{Role: "Admin"},
+			expectedCode:   http.StatusOK,
+			expectedResult: `{"canceled":["running"]}`,
+		},
+		{
+			description:  "forbid viewers to cancel statements",
+			route:        "cancel",
+			user:         &backend.User{Role: "Viewer"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			description:    "probe permissions",
+			route:          "permissions",
+			user:           &backend.User{Role: "Admin"},
+			expectedCode:   http.StatusOK,
+			expectedResult: `{"actions":[{"action":"redshift-data:ListSchemas","status":"denied"}],"missing":["redshift-data:ListSchemas"]}`,
+		},
+		{
+			description:  "forbid viewers to probe permissions",
+			route:        "permissions",
+			user:         &backend.User{Role: "Viewer"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			description:    "explain a query",
+			route:          "explain",
+			body:           `{"rawSQL":"select * from t where $__timeFilter(ts)","from":"0","to":"1000","database":"dev"}`,
+			expectedCode:   http.StatusOK,
+			expectedResult: `{"root":{"id":0,"operation":"XN Seq Scan on t","startupCost":0,"totalCost":1,"rows":10,"width":4}}`,
+		},
+		{
+			description:  "refuse to explain without a query",
+			route:        "explain",
+			expectedCode: http.StatusBadRequest,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.description, func(t *testing.T) {
-			req := httptest.NewRequest("GET", "http://example.com/foo", bytes.NewReader([]byte("{}")))
+			reqBody := tt.body
+			if reqBody == "" {
+				reqBody = "{}"
+			}
+			req := httptest.NewRequest("GET", "http://example.com/foo", bytes.NewReader([]byte(reqBody)))
+			req = req.WithContext(backend.WithUser(req.Context(), tt.user))
 			rw := httptest.NewRecorder()
 			rh := RedshiftResourceHandler{redshift: ds}
-			switch tt.route {
-			case "secrets":
-				rh.secrets(rw, req)
-			case "secret":
-				rh.secret(rw, req)
-			case "clusters":
-				rh.clusters(rw, req)
-			case "workgroups":
-				rh.workgroups(rw, req)
-			default:
+			handler, ok := rh.Routes()["/"+tt.route]
+			if !ok {
 				t.Fatalf("unexpected route %s", tt.route)
 			}
+			handler(rw, req)
 
 			resp := rw.Result()
 			body, err := io.ReadAll(resp.Body)
@@ -113,4 +199,22 @@ func Test_Routes(t *testing.T) {
 	assert.Contains(t, r, "/secret")
 	assert.Contains(t, r, "/workgroups")
 	assert.Contains(t, r, "/clusters")
+	assert.Contains(t, r, "/history")
+	assert.Contains(t, r, "/running")
+	assert.Contains(t, r, "/cancel")
+	assert.Contains(t, r, "/explain")
+	assert.Contains(t, r, "/permissions")
+}
+
+func Test_explainQuery(t *testing.T) {
+	query, err := explainQuery(map[string]string{"rawSQL": "select 1", "from": "1000", "to": "2000", "table": "t", "database": "dev"})
+	assert.NoError(t, err)
+	assert.Equal(t, "select 1", query.RawSQL)
+	assert.Equal(t, "t", query.Table)
+	assert.Equal(t, time.UnixMilli(1000), query.TimeRange.From)
+	assert.Equal(t, time.UnixMilli(2000), query.TimeRange.To)
+	assert.JSONEq(t, `{"database":"dev"}`, string(query.ConnectionArgs))
+
+	_, err = explainQuery(map[string]string{"rawSQL": "select 1", "from": "now-1h"})
+	assert.Error(t, err)
 }
@@ -0,0 +1,201 @@
+package redshift
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const maxChunks = 1000
+
+// timeGroupSQL matches the time bucket macros, capturing their interval
+var timeGroupSQL = regexp.MustCompile(`\$__(?:timeGroup|unixEpochGroup)\(\s*[^,)]+,\s*'?([^')]+?)'?\s*\)`)
+
+// querySplitter runs queries with a split interval as one query per chunk of their time range. The frames
+// of the chunks are concatenated in order. With the async query flow, the chunks are polled by the requests
+// polling the split query.
+type querySplitter struct {
+	chunks *partQueries
+}
+
+func newQuerySplitter() *querySplitter {
+	return &querySplitter{chunks: newPartQueries("split-")}
+}
+
+// queryData wraps fn, which runs requests made of a single query, with the splitter
+func (s *querySplitter) queryData(fn queryDataFunc) queryDataFunc {
+	return func(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		if res, ok, err := s.chunks.poll(ctx, fn, req); ok {
+			return res, err
+		}
+		q := req.Queries[0]
+		if isPoll(q) {
+			return fn(ctx, req)
+		}
+		interval, err := splitInterval(req.PluginContext, q)
+		if err != nil {
+			res := backend.NewQueryDataResponse()
+			res.Responses[q.RefID] = backend.ErrorResponseWithErrorSource(backend.DownstreamError(err))
+			return res, nil
+		}
+		if interval <= 0 || !filtersTimeRange(q) || !splittable(q) {
+			return fn(ctx, req)
+		}
+		if err := checkBuckets(q, interval); err != nil {
+			res := backend.NewQueryDataResponse()
+			res.Responses[q.RefID] = backend.ErrorResponseWithErrorSource(backend.DownstreamError(err))
+			return res, nil
+		}
+		chunks := splitTimeRange(q.TimeRange, interval)
+		if len(chunks) < 2 {
+			return fn(ctx, req)
+		}
+		if len(chunks) > maxChunks {
+			res := backend.NewQueryDataResponse()
+			res.Responses[q.RefID] = backend.ErrorResponseWithErrorSource(backend.DownstreamError(
+				fmt.Errorf("splitting the time range by %s results in more than %d queries, use a larger split interval", interval, maxChunks)))
+			return res, nil
+		}
+
+		parts := make([]backend.DataQuery, len(chunks))
+		for i, chunk := range chunks {
+			parts[i] = q
+			parts[i].TimeRange = chunk
+		}
+		return s.chunks.run(ctx, fn, req, parts, func(responses []backend.DataResponse) backend.DataResponse {
+			frames, err := concatFrames(responses, chunks)
+			if err != nil {
+				return backend.ErrorResponseWithErrorSource(backend.DownstreamError(err))
+			}
+			return backend.DataResponse{Frames: frames}
+		})
+	}
+}
+
+// splittable returns false for queries whose result for a range is not the result for its chunks put
+// together: those aggregating rows other than into time buckets, e.g. a count over the whole range, and
+// those sorting rows in descending order or keeping only some of them
+func splittable(q backend.DataQuery) bool {
+	if limits(q) {
+		return false
+	}
+	query, err := awsds.GetQuery(q)
+	return err == nil && (!aggregates(q) || timeGroupSQL.MatchString(query.RawSQL))
+}
+
+// splitInterval returns the interval the time range of the query is split by, set on the query
+// or as a default on the datasource. Zero means the query is not split.
+func splitInterval(pCtx backend.PluginContext, q backend.DataQuery) (time.Duration, error) {
+	model := struct {
+		SplitInterval string `json:"splitInterval"`
+	}{}
+	if err := json.Unmarshal(q.JSON, &model); err != nil {
+		return 0, nil
+	}
+	value := model.SplitInterval
+	if value == "" {
+		if settings, ok := datasourceSettings(pCtx); ok {
+			value = settings.SplitInterval
+		}
+	}
+	if value == "" {
+		return 0, nil
+	}
+	interval, err := gtime.ParseInterval(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid split interval %q: %w", value, err)
+	}
+	return interval, nil
+}
+
+// checkBuckets returns an error if the query groups rows into time buckets that the chunks would cut
+// in two, since the rows of a bucket must all be aggregated by the same chunk
+func checkBuckets(q backend.DataQuery, interval time.Duration) error {
+	query, err := awsds.GetQuery(q)
+	if err != nil {
+		return nil
+	}
+	for _, match := range timeGroupSQL.FindAllStringSubmatch(query.RawSQL, -1) {
+		bucket := q.Interval
+		if !strings.Contains(match[1], "$__interval") {
+			if bucket, err = gtime.ParseInterval(match[1]); err != nil {
+				continue
+			}
+		}
+		if bucket > 0 && interval%bucket != 0 {
+			return fmt.Errorf("the split interval %s is not a multiple of the %s time buckets of the query, use a split interval made of whole buckets", interval, bucket)
+		}
+	}
+	return nil
+}
+
+// splitTimeRange returns consecutive chunks of the time range, each at most interval long. Chunks start
+// and end on multiples of the interval since the Unix epoch, like the time buckets of $__timeGroup, so
+// that time buckets dividing the interval are never cut in two.
+func splitTimeRange(timeRange backend.TimeRange, interval time.Duration) []backend.TimeRange {
+	chunks := []backend.TimeRange{}
+	for from := timeRange.From; from.Before(timeRange.To) && len(chunks) <= maxChunks; {
+		to := nextBoundary(from, interval)
+		if to.After(timeRange.To) {
+			to = timeRange.To
+		}
+		chunks = append(chunks, backend.TimeRange{From: from, To: to})
+		from = to
+	}
+	return chunks
+}
+
+// nextBoundary returns the first multiple of the interval since the Unix epoch after t
+func nextBoundary(t time.Time, interval time.Duration) time.Time {
+	epoch := time.Unix(0, 0).In(t.Location())
+	return epoch.Add((t.Sub(epoch)/interval + 1) * interval)
+}
+
+// concatFrames concatenates the frames of the chunks in order. $__timeFilter includes both ends of
+// a range, so two consecutive chunks both return rows at the time they meet. Those of the later chunk
+// are kept: for aggregated queries, its bucket starting at that time holds all the rows of the bucket,
+// while the bucket of the previous chunk only holds the rows at that very time.
+func concatFrames(responses []backend.DataResponse, chunks []backend.TimeRange) (data.Frames, error) {
+	first := responses[0].Frames
+	res := make(data.Frames, len(first))
+	for j, frame := range first {
+		res[j] = frame.EmptyCopy()
+	}
+	for i, r := range responses {
+		if len(r.Frames) != len(res) {
+			return nil, fmt.Errorf("the chunks of the split query returned different frames, use a larger split interval or disable splitting")
+		}
+		for j, frame := range r.Frames {
+			if !sameFields(res[j], frame) {
+				return nil, fmt.Errorf("the chunks of the split query returned different fields, use a larger split interval or disable splitting")
+			}
+			appendRows(res[j], frame, func(t time.Time) bool { return i == len(chunks)-1 || t.Before(chunks[i].To) })
+		}
+	}
+	return res, nil
+}
+
+// appendRows appends the rows of src to dst. If the frame has a time field, rows whose time does not pass keep are skipped.
+func appendRows(dst, src *data.Frame, keep func(time.Time) bool) {
+	rows, err := src.RowLen()
+	if err != nil {
+		return
+	}
+	timeIndices := src.TypeIndices(data.FieldTypeTime, data.FieldTypeNullableTime)
+	for row := 0; row < rows; row++ {
+		if len(timeIndices) > 0 {
+			if t, ok := timeAt(src.Fields[timeIndices[0]], row); ok && !keep(t) {
+				continue
+			}
+		}
+		dst.AppendRow(src.RowCopy(row)...)
+	}
+}
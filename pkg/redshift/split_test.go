@@ -0,0 +1,161 @@
+package redshift
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_querySplitter(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	var queried []backend.TimeRange
+	// one row per hour of the queried range, both ends included like $__timeFilter
+	fn := func(_ context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		q := req.Queries[0]
+		mu.Lock()
+		queried = append(queried, q.TimeRange)
+		mu.Unlock()
+		frame := data.NewFrame("", data.NewField("time", nil, []time.Time{}))
+		for ts := q.TimeRange.From; !ts.After(q.TimeRange.To); ts = ts.Add(time.Hour) {
+			frame.AppendRow(ts)
+		}
+		res := backend.NewQueryDataResponse()
+		res.Responses[q.RefID] = backend.DataResponse{Frames: data.Frames{frame}}
+		return res, nil
+	}
+	model, _ := json.Marshal(map[string]string{"refId": "A", "rawSQL": "SELECT time FROM t WHERE $__timeFilter(time)", "splitInterval": "1d"})
+	req := &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{ID: 1}},
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: model, TimeRange: backend.TimeRange{From: start, To: start.Add(60 * time.Hour)}}},
+	}
+
+	res, err := newQuerySplitter().queryData(fn)(context.Background(), req)
+	require.NoError(t, err)
+	assert.Len(t, queried, 3)
+	frame := res.Responses["A"].Frames[0]
+	require.Equal(t, 61, frame.Rows())
+	for i := 0; i < frame.Rows(); i++ {
+		assert.Equal(t, start.Add(time.Duration(i)*time.Hour), frame.At(0, i))
+	}
+}
+
+func Test_splitTimeRange(t *testing.T) {
+	start := time.Unix(0, 0)
+	chunks := splitTimeRange(backend.TimeRange{From: start, To: start.Add(150 * time.Minute)}, time.Hour)
+	assert.Equal(t, []backend.TimeRange{
+		{From: start, To: start.Add(time.Hour)},
+		{From: start.Add(time.Hour), To: start.Add(2 * time.Hour)},
+		{From: start.Add(2 * time.Hour), To: start.Add(150 * time.Minute)},
+	}, chunks)
+}
+
+func Test_splitTimeRange_aligned(t *testing.T) {
+	start := time.Date(2024, 1, 1, 18, 30, 0, 0, time.UTC)
+	chunks := splitTimeRange(backend.TimeRange{From: start, To: start.Add(24 * time.Hour)}, 24*time.Hour)
+	assert.Equal(t, []backend.TimeRange{
+		{From: start, To: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{From: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), To: start.Add(24 * time.Hour)},
+	}, chunks)
+}
+
+func Test_querySplitter_aggregated(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)
+	// the count of events per hour, with one event per minute of the queried range, both ends included like $__timeFilter
+	fn := func(_ context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		q := req.Queries[0]
+		frame := data.NewFrame("", data.NewField("time", nil, []time.Time{}), data.NewField("count", nil, []int64{}))
+		for ts := q.TimeRange.From; !ts.After(q.TimeRange.To); ts = ts.Add(time.Minute) {
+			bucket := ts.Truncate(time.Hour)
+			if rows := frame.Rows(); rows > 0 && frame.At(0, rows-1) == bucket {
+				frame.Set(1, rows-1, frame.At(1, rows-1).(int64)+1)
+				continue
+			}
+			frame.AppendRow(bucket, int64(1))
+		}
+		res := backend.NewQueryDataResponse()
+		res.Responses[q.RefID] = backend.DataResponse{Frames: data.Frames{frame}}
+		return res, nil
+	}
+	request := func(splitInterval string) *backend.QueryDataRequest {
+		model, _ := json.Marshal(map[string]string{
+			"refId":         "A",
+			"rawSQL":        "SELECT $__timeGroup(time, '1h'), count(*) FROM t WHERE $__timeFilter(time) GROUP BY 1 ORDER BY 1",
+			"splitInterval": splitInterval,
+		})
+		return &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{ID: 1}},
+			Queries:       []backend.DataQuery{{RefID: "A", JSON: model, TimeRange: backend.TimeRange{From: start, To: start.Add(60 * time.Hour)}}},
+		}
+	}
+
+	expected, err := fn(context.Background(), request(""))
+	require.NoError(t, err)
+	res, err := newQuerySplitter().queryData(fn)(context.Background(), request("1d"))
+	require.NoError(t, err)
+	// the buckets where chunks meet are those of the chunk they start, with all their rows
+	want, got := expected.Responses["A"].Frames[0], res.Responses["A"].Frames[0]
+	require.Equal(t, want.Rows(), got.Rows())
+	for i := 0; i < want.Rows(); i++ {
+		assert.Equal(t, want.RowCopy(i), got.RowCopy(i))
+	}
+
+	res, err = newQuerySplitter().queryData(fn)(context.Background(), request("90m"))
+	require.NoError(t, err)
+	assert.ErrorContains(t, res.Responses["A"].Error, "not a multiple of the 1h0m0s time buckets")
+}
+
+func Test_querySplitter_async(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var queried []backend.TimeRange
+	fn := asyncQueryData(&queried)
+	model, _ := json.Marshal(map[string]interface{}{
+		"refId":         "A",
+		"rawSQL":        "SELECT time, value FROM t WHERE $__timeFilter(time)",
+		"splitInterval": "1h",
+		"meta":          map[string]string{"queryFlow": "async"},
+	})
+	req := &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{ID: 1}},
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: model, TimeRange: backend.TimeRange{From: start, To: start.Add(3 * time.Hour)}}},
+	}
+
+	s := newQuerySplitter()
+	r := runAsync(t, s.queryData(fn), req)
+	assert.Len(t, queried, 3)
+	frame := r.Frames[0]
+	require.Equal(t, 181, frame.Rows())
+	for i := 0; i < frame.Rows(); i++ {
+		assert.Equal(t, start.Add(time.Duration(i)*time.Minute), frame.At(0, i))
+	}
+	assert.Empty(t, s.chunks.queries)
+}
+
+func Test_querySplitter_notSplittable(t *testing.T) {
+	for _, sql := range []string{
+		"SELECT count(*) FROM t WHERE $__timeFilter(time)",
+		"SELECT time, value FROM t WHERE $__timeFilter(time) ORDER BY time DESC",
+		"SELECT time, value FROM t WHERE $__timeFilter(time) ORDER BY time LIMIT 10",
+	} {
+		calls := 0
+		fn := func(_ context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+			calls++
+			return backend.NewQueryDataResponse(), nil
+		}
+		model, _ := json.Marshal(map[string]string{"refId": "A", "rawSQL": sql, "splitInterval": "1h"})
+		req := &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{ID: 1}},
+			Queries:       []backend.DataQuery{{RefID: "A", JSON: model, TimeRange: backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(0, 0).Add(3 * time.Hour)}}},
+		}
+		_, err := newQuerySplitter().queryData(fn)(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, 1, calls, sql)
+	}
+}
@@ -4,7 +4,7 @@ import { DataSourceInstanceSettings, ScopedVars } from '@grafana/data';
 import { getTemplateSrv } from '@grafana/runtime';
 import { RedshiftVariableSupport } from 'variables';
 
-import { RedshiftDataSourceOptions, RedshiftQuery, defaultQuery } from './types';
+import { RedshiftDataSourceOptions, RedshiftQuery, connectionArgOverrides, defaultQuery } from './types';
 import { RedshiftAnnotationsSupport } from './annotations';
 
 export class DataSource extends DatasourceWithAsyncBackend<RedshiftQuery, RedshiftDataSourceOptions> {
@@ -22,6 +22,19 @@ export class DataSource extends DatasourceWithAsyncBackend<RedshiftQuery, Redshi
 
   filterQuery = filterSQLQuery;
 
-  applyTemplateVariables = (query: RedshiftQuery, scopedVars: ScopedVars) =>
-    applySQLTemplateVariables(query, scopedVars, getTemplateSrv);
+  applyTemplateVariables = (query: RedshiftQuery, scopedVars: ScopedVars): RedshiftQuery => {
+    const res = applySQLTemplateVariables(query, scopedVars, getTemplateSrv);
+    if (!query.connectionArgs) {
+      return res;
+    }
+    // the cluster, workgroup, database user or secret can be selected by a dashboard variable
+    const connectionArgs = { ...res.connectionArgs };
+    for (const key of connectionArgOverrides) {
+      const value = query.connectionArgs[key];
+      if (value) {
+        connectionArgs[key] = getTemplateSrv().replace(value, scopedVars);
+      }
+    }
+    return { ...res, connectionArgs };
+  };
 }
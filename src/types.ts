@@ -22,6 +22,24 @@ export const SelectableFormatOptions: Array<SelectableValue<FormatOptions>> = [
   },
 ];
 
+/**
+ * Connection arguments that select one of the clusters, workgroups, database users or secrets
+ * allowed by the datasource instead of the configured one. They can use template variables.
+ */
+export interface RedshiftConnectionArgs {
+  clusterIdentifier?: string;
+  workgroupName?: string;
+  dbUser?: string;
+  secretARN?: string;
+}
+
+export const connectionArgOverrides: Array<keyof RedshiftConnectionArgs> = [
+  'clusterIdentifier',
+  'workgroupName',
+  'dbUser',
+  'secretARN',
+];
+
 export interface RedshiftQuery extends SQLQuery {
   format: FormatOptions;
 
@@ -30,6 +48,8 @@ export interface RedshiftQuery extends SQLQuery {
   column?: string;
 
   queryID?: string;
+
+  connectionArgs?: SQLQuery['connectionArgs'] & RedshiftConnectionArgs;
 }
 
 export interface RedshiftManagedSecret {